@@ -11,24 +11,35 @@ package bank
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"net/http"
-	"sort"
 	"strings"
+	"sync"
 
 	"github.com/musicbeat/stddata"
+	"github.com/musicbeat/stddata/index"
 )
 
 // BankProvider implements the Provider interface.
 type BankProvider struct {
+	// Source supplies the directory. When it is nil, Load sets it to
+	// an HTTPSource for the Fed's website.
+	Source stddata.Source
+	// CacheDir, when it is not empty, is a directory in which a
+	// snapshot of the last directory that loaded is kept, to be loaded
+	// when Source cannot be read. See stddata.CachedSource.
+	CacheDir string
+	// status is the lifecycle of the data.
+	status stddata.Status
+	// loadMu allows one Load at a time.
+	loadMu sync.Mutex
+	// mu guards loaded, size and the indexes, which Load replaces
+	// together, so that searches see one complete data set.
+	mu          sync.RWMutex
 	loaded      bool
 	size        int
-	bankIndexes map[string]bankIndex
-}
-
-type bankIndex struct {
-	bankMap  map[string][]Bank
-	bankKeys []string
+	bankIndexes map[string]*index.Index[Bank]
 }
 
 // Bank is the information on one bank in the source data.
@@ -52,8 +63,10 @@ type Bank struct {
 	DataViewCode          string // Length 1; Columns 150
 }
 
-// BankResult is the interface{} that is returned from Search
+// BankResult is the interface{} that is returned from Search. Total is the
+// number of keys that matched, of which Banks holds the requested page.
 type BankResult struct {
+	Total int
 	Banks [][]Bank
 }
 
@@ -76,38 +89,71 @@ var ts = [...]int{144, 148}
 var is = [...]int{148, 149}
 var dv = [...]int{149, 150}
 
-var routingNumberMap map[string][]Bank
-var customerNameMap map[string][]Bank
+// recordLength is the length of a record, not counting any filler.
+const recordLength = 150
 
 var fedurl = "http://www.fededirectory.frb.org/FedACHdir.txt"
 
+// indexInfo describes the indexes of the directory, and the "resolve"
+// and "validate" lookups.
+var indexInfo = []stddata.IndexInfo{
+	{Name: "name", Key: "CustomerName", Match: index.Prefix},
+	{Name: "number", Key: "Routing", Match: index.Prefix},
+	{Name: "resolve", Key: "Routing", Match: index.Exact},
+	{Name: "validate", Key: "Routing", Match: index.Exact},
+}
+
 // Load does the heavy lifting of retrieving the Fed's directory
 // of banks, a fixed format text file served via http, and
 // populating maps for searches.
 func (p *BankProvider) Load() (n int, err error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	p.status.Begin()
+	defer func() { p.status.Finish(err) }()
+
 	// Initialize the maps:
-	p.bankIndexes = make(map[string]bankIndex)
-	routingNumberMap = make(map[string][]Bank)
-	customerNameMap = make(map[string][]Bank)
+	routingNumberMap := make(map[string][]Bank)
+	customerNameMap := make(map[string][]Bank)
 
-	res, err := http.Get(fedurl)
+	if p.Source == nil {
+		p.Source = &stddata.HTTPSource{URL: fedurl}
+	}
+	src := p.Source
+	if p.CacheDir != "" {
+		src = &stddata.CachedSource{Source: src, Dir: p.CacheDir}
+	}
+	body, err := p.status.Open(src)
+	if err == stddata.ErrNotModified {
+		// the data set that is served is current
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.size, nil
+	}
 	if err != nil {
-		msg := "Failed to retrieve " + fedurl + ". " + err.Error()
-		return 0, &stddata.ServiceError{msg, http.StatusServiceUnavailable}
+		msg := "Failed to retrieve " + src.String() + ". " + err.Error()
+		return 0, &stddata.ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
 	}
-	defer res.Body.Close()
+	defer body.Close()
 
-	bio := bufio.NewReader(res.Body)
-	for {
+	bio := bufio.NewReader(body)
+	for lineno := 1; ; lineno++ {
 		var b Bank
 		line, err := bio.ReadBytes('\n')
-		if err == io.EOF {
+		if err == io.EOF && len(line) == 0 {
 			break
 		}
-		if err != nil {
-			return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+		if err != nil && err != io.EOF {
+			return 0, &stddata.ServiceError{Msg: err.Error(), Code: http.StatusServiceUnavailable}
+		}
+		sline := strings.TrimRight(string(line), "\r\n")
+		if len(sline) == 0 {
+			continue
+		}
+		if len(sline) < recordLength {
+			msg := fmt.Sprintf("Malformed record at line %d of %s", lineno, src)
+			return 0, &stddata.ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
 		}
-		sline := strings.TrimRight(string(line), "\n")
 
 		b.Routing = strings.TrimSpace(sline[rn[0]:rn[1]])
 		b.OfficeCode = strings.TrimSpace(sline[oc[0]:oc[1]])
@@ -132,28 +178,39 @@ func (p *BankProvider) Load() (n int, err error) {
 		customerNameMap[b.CustomerName] = append(customerNameMap[b.CustomerName], b)
 
 	}
-	p.storeData("number", routingNumberMap)
-	p.storeData("name", customerNameMap)
+	// replace the indexes only once the whole data set has been read
+	p.mu.Lock()
+	p.bankIndexes = map[string]*index.Index[Bank]{
+		"number": index.New(routingNumberMap, index.Prefix),
+		"name":   index.New(customerNameMap, index.Prefix),
+	}
 	p.size = len(routingNumberMap)
 	p.loaded = true
+	p.mu.Unlock()
 	return len(routingNumberMap), err
 }
 
-func (p *BankProvider) storeData(s string, m map[string][]Bank) {
-	// store the map
-	var bi bankIndex
-	bi.bankMap = m
-	// extract the keys
-	bi.bankKeys = make([]string, len(m))
-	i := 0
-	for k, _ := range m {
-		bi.bankKeys[i] = k
-		i++
-	}
-	// sort the keys
-	sort.Strings(bi.bankKeys)
-	// add to bankIndexes
-	p.bankIndexes[s] = bi
+// current returns the indexes of the most recent successful Load.
+func (p *BankProvider) current() (loaded bool, indexes map[string]*index.Index[Bank]) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.loaded, p.bankIndexes
+}
+
+// State implements the Provider interface.
+func (p *BankProvider) State() (state stddata.State, err error) {
+	return p.status.State()
+}
+
+// LoadInfo implements the Provider interface.
+func (p *BankProvider) LoadInfo() stddata.LoadInfo {
+	return p.status.Info()
+}
+
+// Indexes implements the Provider interface.
+func (p *BankProvider) Indexes() []stddata.IndexInfo {
+	_, indexes := p.current()
+	return stddata.DescribeIndexes(indexInfo, indexes)
 }
 
 // Search returns a collection as an interface{} and error. The collection
@@ -164,42 +221,27 @@ func (p *BankProvider) storeData(s string, m map[string][]Bank) {
 // any matching Banks are returned in the result.
 // Search can also "dump" an index. When the value of query is "_dump", the index specified
 // is used to supply the entire data set, in the order of the index.
-func (p *BankProvider) Search(index string, query string) (result interface{}, err error) {
+// The indexes "validate" and "resolve" are not maps: query is a routing number,
+// and a ValidationResult or a ResolveResult is returned.
+func (p *BankProvider) Search(index string, query string, page stddata.Page) (result interface{}, err error) {
 	// make sure the data is loaded
-	if p.loaded != true {
-		return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+	loaded, indexes := p.current()
+	if loaded != true {
+		return nil, p.status.Unavailable("Bank directory")
+	}
+	switch index {
+	case "validate":
+		return p.validate(query), nil
+	case "resolve":
+		return p.Resolve(query)
 	}
-	bi, found := p.bankIndexes[index]
+	bi, found := indexes[index]
 	if !found {
 		// search cannot be performed
 		msg := "No index on " + index
-		return nil, &stddata.ServiceError{msg, http.StatusBadRequest}
+		return nil, &stddata.ServiceError{Msg: msg, Code: http.StatusBadRequest}
 	}
-	result = doSearch(bi, query)
+	banks, total := bi.SearchPage(query, page.Offset, page.Limit)
+	result = BankResult{Total: total, Banks: banks}
 	return result, nil
 }
-func doSearch(bi bankIndex, query string) (res BankResult) {
-	// the "reserved" query term "_dump" is handled by returning all the
-	// results in the order of the index.
-	dump := query == "_dump"
-	// prepare the response. allocate enough space for the response to be the
-	// entire data set.
-	tmp := make([][]Bank, len(bi.bankKeys))
-	// brute force the sorted list of keys, looking for a match to 'query.*'.
-	// add each match to the result array. The results are added in the
-	// order of the sorted keys, so the results are sorted.
-	i := 0
-	for k := range bi.bankKeys {
-		if dump {
-			tmp[i] = bi.bankMap[bi.bankKeys[k]]
-			i++
-		} else if len(bi.bankKeys[k]) >= len(query) {
-			if strings.EqualFold(query, bi.bankKeys[k][0:len(query)]) {
-				tmp[i] = bi.bankMap[bi.bankKeys[k]]
-				i++
-			}
-		}
-	}
-	res.Banks = tmp[0:i]
-	return res
-}
@@ -1,7 +1,12 @@
 package bank
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	. "github.com/musicbeat/stddata"
@@ -9,47 +14,197 @@ import (
 
 var p Provider
 
-// number of banks (at least for now). This changes nearly every day,
-// so failures of the TestBankProviderLoad often fail.
-var expected = 19817
+// number of banks in testdata/FedACHdir.txt, a recorded snapshot of
+// the Fed's directory.
+var expected = 219
+
+// fixture stands in for the Fed's website, serving the files in testdata.
+func fixture() *httptest.Server {
+	return httptest.NewServer(http.FileServer(http.Dir("testdata")))
+}
 
 func TestBankProviderLoad(t *testing.T) {
-	fmt.Println("Test: bank.Load")
-	p = new(BankProvider)
+	ts := fixture()
+	defer ts.Close()
+	p = &BankProvider{Source: &HTTPSource{URL: ts.URL + "/FedACHdir.txt"}}
 	n, err := p.Load()
 	if err != nil {
-		t.Fatal()
+		t.Fatalf("Err %v\n", err)
 	}
 	if n != expected {
 		t.Fatalf("Expected to load %d, loaded %d\n", expected, n)
 	}
 }
+func TestBankProviderLoadNotFound(t *testing.T) {
+	ts := fixture()
+	defer ts.Close()
+	bp := &BankProvider{Source: &HTTPSource{URL: ts.URL + "/missing.txt"}}
+	_, err := bp.Load()
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 ServiceError, got %v\n", err)
+	}
+	if state, err := bp.State(); state != Failed || err == nil {
+		t.Fatalf("Expected failed state, got %v, %v\n", state, err)
+	}
+	_, err = bp.Search("name", "BANK", Page{})
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 ServiceError, got %v\n", err)
+	}
+}
+func TestBankProviderNotLoaded(t *testing.T) {
+	bp := new(BankProvider)
+	if state, _ := bp.State(); state != NotLoaded {
+		t.Fatalf("Expected not loaded, got %v\n", state)
+	}
+	_, err := bp.Search("name", "BANK", Page{})
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 ServiceError, got %v\n", err)
+	}
+}
+func TestBankServiceNotLoaded(t *testing.T) {
+	s := &Service{Provider: new(BankProvider), EntityName: "bank"}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/bank?name=BANK", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Expected 503 with Retry-After, got %d %q\n", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+func TestBankProviderLoadMalformed(t *testing.T) {
+	data := "011000015O0110000150020802000000000FEDERAL RESERVE BANK\n"
+	bp := &BankProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	_, err := bp.Load()
+	if err == nil {
+		t.Fatal("Expected malformed record to fail the load")
+	}
+}
+func TestBankProviderLoadTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/FedACHdir.txt")
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	// cut the snapshot off in the middle of a record
+	bp := &BankProvider{Source: &EmbeddedSource{Data: data[:1000]}}
+	_, err = bp.Load()
+	if err == nil {
+		t.Fatal("Expected truncated file to fail the load")
+	}
+}
+func TestBankProviderLoadNoTrailingNewline(t *testing.T) {
+	data := "011000015O0110000150020802000000000FEDERAL RESERVE BANK                1000 PEACHTREE ST N.E.              ATLANTA             GA303094470866234568111"
+	bp := &BankProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	n, err := bp.Load()
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected to load 1, loaded %d\n", n)
+	}
+}
+func TestBankIndexes(t *testing.T) {
+	info := p.Indexes()
+	var names []string
+	for _, ix := range info {
+		names = append(names, ix.Name)
+	}
+	if got := strings.Join(names, ","); got != "name,number,resolve,validate" {
+		t.Fatalf("Unexpected indexes %s\n", got)
+	}
+	if info[1].Key != "Routing" || info[1].Cardinality != expected || info[3].Cardinality != 0 {
+		t.Fatalf("Unexpected index %+v, %+v\n", info[1], info[3])
+	}
+}
 func TestBankNameSearch(t *testing.T) {
 	// name search:
-	matches, err := p.Search("name", "AB")
+	matches, err := p.Search("name", "AB", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(BankResult).Banks); n != 4 {
+		t.Fatalf("Expected 4 names, got %d\n", n)
+	}
 }
 func TestBankNameSearchLowerCase(t *testing.T) {
 	// name search:
-	matches, err := p.Search("name", "ab")
+	matches, err := p.Search("name", "ab", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(BankResult).Banks); n != 4 {
+		t.Fatalf("Expected 4 names, got %d\n", n)
+	}
 }
 func TestBankNumberSearch(t *testing.T) {
 	// number search:
-	numbers, err := p.Search("number", "123")
+	numbers, err := p.Search("number", "0110", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	banks := numbers.(BankResult).Banks
+	if len(banks) != 21 {
+		t.Fatalf("Expected 21 numbers, got %d\n", len(banks))
+	}
+	if b := banks[0][0]; b.Routing != "011000015" || b.CustomerName != "FEDERAL RESERVE BANK" {
+		t.Fatalf("Unexpected first bank %+v\n", b)
+	}
+}
+func TestBankDump(t *testing.T) {
+	numbers, err := p.Search("number", "_dump", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n := len(numbers.(BankResult).Banks); n != expected {
+		t.Fatalf("Expected %d numbers, got %d\n", expected, n)
+	}
+}
+func TestBankDumpPage(t *testing.T) {
+	numbers, err := p.Search("number", "_dump", Page{Offset: 215, Limit: 10})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("numbers %s\n", numbers)
+	res := numbers.(BankResult)
+	if res.Total != expected || len(res.Banks) != 4 {
+		t.Fatalf("Expected 4 of %d numbers, got %d of %d\n", expected, len(res.Banks), res.Total)
+	}
+}
+func TestBankServicePage(t *testing.T) {
+	s := &Service{Provider: p, EntityName: "bank"}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/bank?index=number&q=0110&offset=20&limit=5", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", w.Code)
+	}
+	var res BankResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if res.Total != 21 || len(res.Banks) != 1 {
+		t.Fatalf("Expected 1 of 21 numbers, got %d of %d\n", len(res.Banks), res.Total)
+	}
+}
+func TestBankServiceCSV(t *testing.T) {
+	s := &Service{Provider: p, EntityName: "bank"}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/bank?index=number&q=0110000&fields=Routing,CustomerName", nil)
+	r.Header.Set("Accept", "text/csv")
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Unexpected response %d %s\n", w.Code, w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if lines[0] != "Routing,CustomerName" || !strings.HasPrefix(lines[1], "011000015,") {
+		t.Fatalf("Unexpected CSV %s\n", w.Body.String())
+	}
+}
+func TestBankBadIndex(t *testing.T) {
+	_, err := p.Search("city", "A", Page{})
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 ServiceError, got %v\n", err)
+	}
 }
 func BenchmarkNameSearch(b *testing.B) {
-	p = new(BankProvider)
+	ts := fixture()
+	defer ts.Close()
+	p = &BankProvider{Source: &HTTPSource{URL: ts.URL + "/FedACHdir.txt"}}
 	n, err := p.Load()
 	if err != nil {
 		b.Fatal()
@@ -58,11 +213,188 @@ func BenchmarkNameSearch(b *testing.B) {
 		b.Fatalf("Expected to load %d, loaded %d\n", expected, n)
 	}
 	b.ResetTimer()
-	fmt.Printf("start runs...")
 	for i := 0; i < b.N; i++ {
-		_, err := p.Search("name", "ab")
+		_, err := p.Search("name", "ab", Page{})
 		if err != nil {
 			b.Fatalf("Err %v\n", err)
 		}
 	}
 }
+func TestBankProvidersCoexist(t *testing.T) {
+	// yesterday's and today's directories, side by side
+	data := "011000015O0110000150020802000000000FEDERAL RESERVE BANK                1000 PEACHTREE ST N.E.              ATLANTA             GA303094470866234568111\n"
+	other := &BankProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	if _, err := other.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	numbers, err := p.Search("number", "_dump", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n := len(numbers.(BankResult).Banks); n != expected {
+		t.Fatalf("Expected %d numbers, got %d\n", expected, n)
+	}
+	numbers, err = other.Search("number", "_dump", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n := len(numbers.(BankResult).Banks); n != 1 {
+		t.Fatalf("Expected 1 number, got %d\n", n)
+	}
+}
+func TestBankProviderReloadNotModified(t *testing.T) {
+	ts := fixture()
+	defer ts.Close()
+	bp := &BankProvider{Source: &HTTPSource{URL: ts.URL + "/FedACHdir.txt"}}
+	if _, err := bp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	loaded := bp.LoadInfo()
+	// the fixture server answers If-Modified-Since with 304
+	n, err := bp.Load()
+	if err != nil || n != expected {
+		t.Fatalf("Expected %d unchanged, got %d, %v\n", expected, n, err)
+	}
+	info := bp.LoadInfo()
+	if info.LoadedAt != loaded.LoadedAt || info.Checksum != loaded.Checksum || !info.CheckedAt.After(loaded.CheckedAt) {
+		t.Fatalf("Unexpected %+v after %+v\n", info, loaded)
+	}
+	if _, err := bp.Search("number", "011000015", Page{}); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+}
+func TestBankProviderCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	ts := fixture()
+	url := ts.URL + "/FedACHdir.txt"
+	bp := &BankProvider{Source: &HTTPSource{URL: url}, CacheDir: dir}
+	if _, err := bp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	ts.Close()
+	// a new provider loads the snapshot when the website is unreachable
+	bp = &BankProvider{Source: &HTTPSource{URL: url, Retries: -1}, CacheDir: dir}
+	n, err := bp.Load()
+	if err != nil || n != expected {
+		t.Fatalf("Expected %d from the snapshot, got %d, %v\n", expected, n, err)
+	}
+	if info := bp.LoadInfo(); !info.Stale || info.SnapshotAt.IsZero() {
+		t.Fatalf("Expected a stale data set, got %+v\n", info)
+	}
+	if _, err := bp.Search("number", "011000015", Page{}); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+}
+func TestBankProviderReloadAfterTruncatedTransfer(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/FedACHdir.txt")
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	// the upstream sends v1, then a truncated v2, then v2 whole, and
+	// answers a request for the version it last sent with 304
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inm := r.Header.Get("If-None-Match")
+		requests = append(requests, inm)
+		switch {
+		case len(requests) == 1:
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(data)
+		case inm == `"v2"`:
+			w.WriteHeader(http.StatusNotModified)
+		case len(requests) == 2:
+			w.Header().Set("ETag", `"v2"`)
+			w.Write(data[:len(data)/2])
+		default:
+			w.Header().Set("ETag", `"v2"`)
+			w.Write(data)
+		}
+	}))
+	defer ts.Close()
+	bp := &BankProvider{Source: &HTTPSource{URL: ts.URL}}
+	if _, err := bp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if _, err := bp.Load(); err == nil {
+		t.Fatal("Expected the load of a truncated directory to fail")
+	}
+	loaded := bp.LoadInfo()
+	n, err := bp.Load()
+	if err != nil || n != expected {
+		t.Fatalf("Expected %d, got %d, %v\n", expected, n, err)
+	}
+	if requests[2] != `"v1"` {
+		t.Fatalf("Expected the reload to ask for a change from v1, got %q\n", requests[2])
+	}
+	if info := bp.LoadInfo(); info.LastError != nil || !info.LoadedAt.After(loaded.LoadedAt) {
+		t.Fatalf("Expected v2 to be loaded, got %+v\n", info)
+	}
+	if _, err := bp.Load(); err != nil || requests[3] != `"v2"` {
+		t.Fatalf("Expected v2 to be current, got %v after asking for %q\n", err, requests[3])
+	}
+}
+func TestBankProviderFailedLoadKeepsData(t *testing.T) {
+	bp := &BankProvider{Source: &FileSource{Path: "testdata/FedACHdir.txt"}}
+	if _, err := bp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	bp.Source = &FileSource{Path: "testdata/missing.txt"}
+	if _, err := bp.Load(); err == nil {
+		t.Fatal("Expected the load of a missing file to fail")
+	}
+	numbers, err := bp.Search("number", "_dump", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n := len(numbers.(BankResult).Banks); n != expected {
+		t.Fatalf("Expected %d numbers, got %d\n", expected, n)
+	}
+}
+func TestConcurrentSearchAndReload(t *testing.T) {
+	// run with -race: searches, through the Service and directly, while
+	// the directory is reloaded.
+	s := new(Service)
+	if err := s.LoadProvider(&BankProvider{Source: &FileSource{Path: "testdata/FedACHdir.txt"}}, "bank"); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	done := make(chan struct{})
+	errs := make(chan error, 8)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					errs <- nil
+					return
+				default:
+				}
+				res, err := s.Provider.Search("name", "a", Page{})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(res.(BankResult).Banks) == 0 {
+					errs <- fmt.Errorf("Expected names, got none")
+					return
+				}
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, httptest.NewRequest("GET", "/bank?number=0110", nil))
+				if w.Code != http.StatusOK {
+					errs <- fmt.Errorf("Expected 200, got %d", w.Code)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := s.Reload(); err != nil {
+			t.Fatalf("Err %v\n", err)
+		}
+	}
+	close(done)
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Err %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// OfficeCode tells whether a routing number belongs to an
+// institution's main office or to a branch.
+type OfficeCode string
+
+// Values of OfficeCode:
+const (
+	MainOffice   OfficeCode = "O"
+	BranchOffice OfficeCode = "B"
+)
+
+func (c OfficeCode) String() string {
+	switch c {
+	case MainOffice:
+		return "main"
+	case BranchOffice:
+		return "branch"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler, so that the decoded
+// form of the code is used in json.
+func (c OfficeCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, reading the
+// decoded form of the code.
+func (c *OfficeCode) UnmarshalText(text []byte) (err error) {
+	*c, err = parseCode(text, MainOffice, BranchOffice)
+	return err
+}
+
+// RecordTypeCode tells where items for a routing number are sent.
+type RecordTypeCode string
+
+// Values of RecordTypeCode:
+const (
+	FederalReserveBank RecordTypeCode = "0"
+	CustomerRouting    RecordTypeCode = "1"
+	NewRouting         RecordTypeCode = "2"
+)
+
+func (c RecordTypeCode) String() string {
+	switch c {
+	case FederalReserveBank:
+		return "federal reserve bank"
+	case CustomerRouting:
+		return "send to customer routing number"
+	case NewRouting:
+		return "send to new routing number"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c RecordTypeCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *RecordTypeCode) UnmarshalText(text []byte) (err error) {
+	*c, err = parseCode(text, FederalReserveBank, CustomerRouting, NewRouting)
+	return err
+}
+
+// InstitutionStatusCode tells what kinds of items an institution
+// receives. The Fed's directory format documents one value.
+type InstitutionStatusCode string
+
+// Values of InstitutionStatusCode:
+const (
+	ReceivesGovernmentAndCommercial InstitutionStatusCode = "1"
+)
+
+func (c InstitutionStatusCode) String() string {
+	switch c {
+	case ReceivesGovernmentAndCommercial:
+		return "receives government and commercial"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c InstitutionStatusCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *InstitutionStatusCode) UnmarshalText(text []byte) (err error) {
+	*c, err = parseCode(text, ReceivesGovernmentAndCommercial)
+	return err
+}
+
+// DataViewCode tells which view of the directory a record belongs to.
+// The Fed's directory format documents one value.
+type DataViewCode string
+
+// Values of DataViewCode:
+const (
+	CurrentView DataViewCode = "1"
+)
+
+func (c DataViewCode) String() string {
+	switch c {
+	case CurrentView:
+		return "current view"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c DataViewCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *DataViewCode) UnmarshalText(text []byte) (err error) {
+	*c, err = parseCode(text, CurrentView)
+	return err
+}
+
+// parseCode returns the one of codes whose decoded form is text. The
+// decoded form of a code that is not known, "unknown", gives the zero
+// code.
+func parseCode[C interface {
+	~string
+	String() string
+}](text []byte, codes ...C) (C, error) {
+	var zero C
+	for _, c := range append(codes, zero) {
+		if c.String() == string(text) {
+			return c, nil
+		}
+	}
+	return zero, errors.New("unknown code " + string(text))
+}
+
+// Decoded is the typed form of the coded fields of a Bank.
+type Decoded struct {
+	Office            OfficeCode
+	RecordType        RecordTypeCode
+	ChangeDate        *time.Time `json:",omitempty"`
+	InstitutionStatus InstitutionStatusCode
+	DataView          DataViewCode
+	Phone             string `json:",omitempty"`
+	ZIP               string `json:",omitempty"`
+}
+
+// Office returns the decoded OfficeCode.
+func (b Bank) Office() OfficeCode {
+	return OfficeCode(b.OfficeCode)
+}
+
+// RecordType returns the decoded RecordTypeCode.
+func (b Bank) RecordType() RecordTypeCode {
+	return RecordTypeCode(b.RecordTypeCode)
+}
+
+// InstitutionStatus returns the decoded InstitutionStatusCode.
+func (b Bank) InstitutionStatus() InstitutionStatusCode {
+	return InstitutionStatusCode(b.InstitutionStatusCode)
+}
+
+// DataView returns the decoded DataViewCode.
+func (b Bank) DataView() DataViewCode {
+	return DataViewCode(b.DataViewCode)
+}
+
+// Changed returns the ChangeDate, which the directory writes as MMDDYY.
+func (b Bank) Changed() (time.Time, error) {
+	return time.Parse("010206", b.ChangeDate)
+}
+
+// Phone returns the telephone number in E.164 form, for example
+// +18772345681. It returns "" if the number is incomplete.
+func (b Bank) Phone() string {
+	n := b.TelephoneAreaCode + b.TelephonePrefixNumber + b.TelephoneSuffixNumber
+	if len(n) != 10 {
+		return ""
+	}
+	return "+1" + n
+}
+
+// ZIP returns the ZIP code, in ZIP+4 form when the directory has
+// an extension.
+func (b Bank) ZIP() string {
+	if b.ZipcodeExtension == "" || b.ZipcodeExtension == "0000" {
+		return b.Zipcode
+	}
+	return b.Zipcode + "-" + b.ZipcodeExtension
+}
+
+// Decode returns the typed form of b's coded fields.
+func (b Bank) Decode() (d Decoded) {
+	d.Office = b.Office()
+	d.RecordType = b.RecordType()
+	if t, err := b.Changed(); err == nil {
+		d.ChangeDate = &t
+	}
+	d.InstitutionStatus = b.InstitutionStatus()
+	d.DataView = b.DataView()
+	d.Phone = b.Phone()
+	d.ZIP = b.ZIP()
+	return d
+}
+
+// MarshalJSON implements json.Marshaler. The json has the raw fields
+// of the directory, and their decoded forms in Decoded.
+func (b Bank) MarshalJSON() ([]byte, error) {
+	type raw Bank
+	return json.Marshal(struct {
+		raw
+		Decoded Decoded
+	}{raw(b), b.Decode()})
+}
@@ -0,0 +1,109 @@
+package bank
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/musicbeat/stddata"
+)
+
+func TestDecode(t *testing.T) {
+	res, err := p.Search("number", "011000015", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	b := res.(BankResult).Banks[0][0]
+	if b.Office() != MainOffice || b.RecordType() != FederalReserveBank {
+		t.Fatalf("Unexpected codes %+v\n", b)
+	}
+	if b.InstitutionStatus() != ReceivesGovernmentAndCommercial || b.DataView() != CurrentView {
+		t.Fatalf("Unexpected status %+v\n", b)
+	}
+	changed, err := b.Changed()
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if !changed.Equal(time.Date(2002, time.February, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected change date %v\n", changed)
+	}
+	if b.Phone() != "+18662345681" {
+		t.Fatalf("Unexpected phone %s\n", b.Phone())
+	}
+	if b.ZIP() != "30309-4470" {
+		t.Fatalf("Unexpected ZIP %s\n", b.ZIP())
+	}
+}
+func TestDecodeIncomplete(t *testing.T) {
+	b := Bank{Zipcode: "02110", ZipcodeExtension: "0000", TelephoneAreaCode: "617"}
+	if b.ZIP() != "02110" || b.Phone() != "" {
+		t.Fatalf("Unexpected ZIP %s, phone %s\n", b.ZIP(), b.Phone())
+	}
+	if d := b.Decode(); d.ChangeDate != nil || d.Office.String() != "unknown" {
+		t.Fatalf("Unexpected decoded %+v\n", d)
+	}
+}
+func TestMarshalJSON(t *testing.T) {
+	res, err := p.Search("number", "011000015", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	j, err := json.Marshal(res.(BankResult).Banks[0][0])
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	for _, s := range []string{`"OfficeCode":"O"`, `"ChangeDate":"020802"`, `"Office":"main"`,
+		`"ChangeDate":"2002-02-08T00:00:00Z"`, `"Phone":"+18662345681"`} {
+		if !strings.Contains(string(j), s) {
+			t.Errorf("Expected %s in %s\n", s, j)
+		}
+	}
+	// the raw fields still round trip
+	var b Bank
+	if err := json.Unmarshal(j, &b); err != nil || b.Routing != "011000015" {
+		t.Fatalf("Unexpected round trip %+v, %v\n", b, err)
+	}
+	// and so do the decoded ones
+	var v struct{ Decoded Decoded }
+	if err := json.Unmarshal(j, &v); err != nil || !reflect.DeepEqual(v.Decoded, b.Decode()) {
+		t.Fatalf("Unexpected decoded round trip %+v, %v\n", v.Decoded, err)
+	}
+}
+func TestUnmarshalCodes(t *testing.T) {
+	var d Decoded
+	j := `{"Office":"branch","RecordType":"send to new routing number","InstitutionStatus":"unknown","DataView":"current view"}`
+	if err := json.Unmarshal([]byte(j), &d); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if d.Office != BranchOffice || d.RecordType != NewRouting || d.InstitutionStatus != "" || d.DataView != CurrentView {
+		t.Fatalf("Unexpected decoded %+v\n", d)
+	}
+	if err := json.Unmarshal([]byte(`{"Office":"annex"}`), &d); err == nil {
+		t.Fatal("Expected an unknown office to fail")
+	}
+}
+func TestServiceDecodedField(t *testing.T) {
+	s := &Service{Provider: p, EntityName: "bank"}
+	for _, url := range []string{
+		"/bank?index=number&q=011000015&fields=Decoded",
+		"/bank?index=number&q=011000015&fields=Routing,Decoded&format=ndjson",
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Decoded"`) || !strings.Contains(w.Body.String(), `"main"`) {
+			t.Fatalf("%s: unexpected response %d %s\n", url, w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), `"CustomerName"`) {
+			t.Fatalf("%s: expected only the fields asked for, got %s\n", url, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/bank?index=number&q=011000015&fields=Color", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d\n", w.Code)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bank
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/musicbeat/stddata"
+)
+
+// ValidationResult is the interface{} that is returned from a
+// "validate" Search.
+type ValidationResult struct {
+	Routing          string
+	WellFormed       bool
+	Error            string `json:",omitempty"`
+	Exists           bool
+	Superseded       bool
+	NewRoutingNumber string `json:",omitempty"`
+}
+
+// ValidateRouting checks that routing is a well formed ABA routing
+// number: nine digits, a first two digits that fall in one of the
+// ranges assigned by the Federal Reserve, and a check digit that
+// satisfies the 3-7-1 checksum. It returns nil if routing is well
+// formed, and an error describing the first problem found otherwise.
+func ValidateRouting(routing string) error {
+	if len(routing) != 9 {
+		return errors.New("Routing number must be 9 digits")
+	}
+	var d [9]int
+	for i := 0; i < len(routing); i++ {
+		if routing[i] < '0' || routing[i] > '9' {
+			return errors.New("Routing number must be 9 digits")
+		}
+		d[i] = int(routing[i] - '0')
+	}
+	// the first two digits identify the Federal Reserve district:
+	//	00	United States Government
+	//	01-12	Federal Reserve Banks
+	//	21-32	Thrift institutions
+	//	61-72	Electronic transactions
+	//	80	Traveler's checks
+	prefix, _ := strconv.Atoi(routing[0:2])
+	switch {
+	case prefix == 0:
+	case prefix >= 1 && prefix <= 12:
+	case prefix >= 21 && prefix <= 32:
+	case prefix >= 61 && prefix <= 72:
+	case prefix == 80:
+	default:
+		return errors.New("Routing number prefix " + routing[0:2] + " is not assigned to a Federal Reserve district")
+	}
+	// the checksum weights the digits 3, 7, 1, 3, 7, 1, 3, 7, 1
+	sum := 3*(d[0]+d[3]+d[6]) + 7*(d[1]+d[4]+d[7]) + (d[2] + d[5] + d[8])
+	if sum%10 != 0 {
+		return errors.New("Routing number check digit is incorrect")
+	}
+	return nil
+}
+
+// validate reports whether routing is well formed, and whether it
+// appears in the loaded directory. A routing number is superseded when
+// it has a replacement, as Resolve follows it.
+func (p *BankProvider) validate(routing string) (res ValidationResult) {
+	res.Routing = routing
+	if err := ValidateRouting(routing); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.WellFormed = true
+	_, indexes := p.current()
+	banks, found := indexes["number"].Get(routing)
+	if !found {
+		return res
+	}
+	res.Exists = true
+	if r, replaced := replacement(banks); replaced {
+		res.Superseded = true
+		res.NewRoutingNumber = r.NewRoutingNumber
+	}
+	return res
+}
+
+// Replacement is one step in a chain of routing number replacements.
+type Replacement struct {
+	Routing          string
+	ChangeDate       string
+	NewRoutingNumber string
+}
+
+// ResolveResult is the interface{} that is returned from a "resolve"
+// Search. Current is the routing number that is in use after following
+// the Chain of replacements from Routing.
+type ResolveResult struct {
+	Routing string
+	Current string
+	Chain   []Replacement
+}
+
+// Resolve follows the replacements of routing to the routing number that
+// is currently in use. A record with record type code 2 directs items to
+// its NewRoutingNumber, which may in turn have been replaced. Resolve
+// returns an error if routing is not in the directory, or if the
+// replacements form a cycle.
+func (p *BankProvider) Resolve(routing string) (res ResolveResult, err error) {
+	loaded, indexes := p.current()
+	if loaded != true {
+		return res, p.status.Unavailable("Bank directory")
+	}
+	numbers := indexes["number"]
+	if _, found := numbers.Get(routing); !found {
+		msg := "No routing number " + routing
+		return res, &stddata.ServiceError{Msg: msg, Code: http.StatusNotFound}
+	}
+	res.Routing = routing
+	seen := map[string]bool{routing: true}
+	current := routing
+	for {
+		banks, _ := numbers.Get(current)
+		r, replaced := replacement(banks)
+		if !replaced {
+			break
+		}
+		res.Chain = append(res.Chain, r)
+		current = r.NewRoutingNumber
+		if seen[current] {
+			msg := "Routing number replacements for " + routing + " form a cycle at " + current
+			return res, &stddata.ServiceError{Msg: msg, Code: http.StatusConflict}
+		}
+		seen[current] = true
+	}
+	res.Current = current
+	return res, nil
+}
+
+// replacement returns the replacement recorded for a routing number, if
+// any of its records has one. A record with record type code 2 whose
+// NewRoutingNumber is all zeros, or is its own routing number, names no
+// replacement. validate and Resolve both use this rule.
+func replacement(banks []Bank) (r Replacement, replaced bool) {
+	for _, b := range banks {
+		if b.RecordType() != NewRouting || strings.Trim(b.NewRoutingNumber, "0") == "" {
+			continue
+		}
+		if b.NewRoutingNumber == b.Routing {
+			continue
+		}
+		r.Routing = b.Routing
+		r.ChangeDate = b.ChangeDate
+		r.NewRoutingNumber = b.NewRoutingNumber
+		return r, true
+	}
+	return r, false
+}
@@ -0,0 +1,160 @@
+package bank
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/musicbeat/stddata"
+)
+
+func TestValidateRouting(t *testing.T) {
+	good := []string{"011000015", "011000028", "122203950", "021000021"}
+	for _, r := range good {
+		if err := ValidateRouting(r); err != nil {
+			t.Errorf("Expected %s to be valid, got %v\n", r, err)
+		}
+	}
+	bad := []string{"", "01100001", "0110000150", "01100001X", "011000016", "131000017", "990000016"}
+	for _, r := range bad {
+		if err := ValidateRouting(r); err == nil {
+			t.Errorf("Expected %s to be invalid\n", r)
+		}
+	}
+}
+func TestValidateSearch(t *testing.T) {
+	res, err := p.Search("validate", "011000015", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	v := res.(ValidationResult)
+	if !v.WellFormed || !v.Exists || v.Superseded {
+		t.Fatalf("Unexpected result %+v\n", v)
+	}
+}
+func TestValidateSearchSuperseded(t *testing.T) {
+	res, err := p.Search("validate", "011001962", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	v := res.(ValidationResult)
+	if !v.WellFormed || !v.Exists || !v.Superseded || v.NewRoutingNumber != "122203950" {
+		t.Fatalf("Unexpected result %+v\n", v)
+	}
+}
+func TestValidateSearchMalformed(t *testing.T) {
+	res, err := p.Search("validate", "011000016", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	v := res.(ValidationResult)
+	if v.WellFormed || v.Exists || v.Error == "" {
+		t.Fatalf("Unexpected result %+v\n", v)
+	}
+}
+func TestValidateSearchUnknown(t *testing.T) {
+	// well formed, but not in the directory
+	res, err := p.Search("validate", "021000021", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	v := res.(ValidationResult)
+	if !v.WellFormed || v.Exists {
+		t.Fatalf("Unexpected result %+v\n", v)
+	}
+}
+func TestResolveSearch(t *testing.T) {
+	res, err := p.Search("resolve", "011001962", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	r := res.(ResolveResult)
+	if r.Current != "122203950" || len(r.Chain) != 1 || r.Chain[0].ChangeDate != "080312" {
+		t.Fatalf("Unexpected result %+v\n", r)
+	}
+}
+func TestResolveSearchCurrent(t *testing.T) {
+	res, err := p.Search("resolve", "011000015", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	r := res.(ResolveResult)
+	if r.Current != "011000015" || len(r.Chain) != 0 {
+		t.Fatalf("Unexpected result %+v\n", r)
+	}
+}
+func TestResolveSearchMetrics(t *testing.T) {
+	// a routing number that was never replaced is still one result
+	s := &Service{Provider: p, EntityName: "bank", Metrics: NewMetrics()}
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/bank?resolve=011000015", nil))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/bank?validate=011000015", nil))
+	w := httptest.NewRecorder()
+	s.Metrics.Handler(s).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`stddata_result_size_sum{provider="bank",index="resolve"} 1`,
+		`stddata_result_size_sum{provider="bank",index="validate"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Fatalf("Expected %s in\n%s\n", line, w.Body.String())
+		}
+	}
+}
+func TestResolveSearchUnknown(t *testing.T) {
+	_, err := p.Search("resolve", "021000021", Page{})
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 ServiceError, got %v\n", err)
+	}
+}
+
+// record makes a directory record for routing, replaced by newRouting.
+func record(routing string, newRouting string) string {
+	tmpl := "011000015O0110000152020802000000000FEDERAL RESERVE BANK                1000 PEACHTREE ST N.E.              ATLANTA             GA303094470866234568111\n"
+	return routing + tmpl[9:26] + newRouting + tmpl[35:]
+}
+
+func TestResolveChain(t *testing.T) {
+	data := record("011000015", "011000028") + record("011000028", "011000138") + record("011000138", "000000000")
+	bp := &BankProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	if _, err := bp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	r, err := bp.Resolve("011000015")
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if r.Current != "011000138" || len(r.Chain) != 2 || r.Chain[1].Routing != "011000028" {
+		t.Fatalf("Unexpected result %+v\n", r)
+	}
+}
+func TestValidateAgreesWithResolve(t *testing.T) {
+	// record type 2, but with no replacement
+	data := record("011000015", "000000000") + record("011000028", "011000028")
+	bp := &BankProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	if _, err := bp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	for _, routing := range []string{"011000015", "011000028"} {
+		res, err := bp.Search("validate", routing, Page{})
+		if err != nil {
+			t.Fatalf("Err %v\n", err)
+		}
+		if v := res.(ValidationResult); !v.Exists || v.Superseded || v.NewRoutingNumber != "" {
+			t.Errorf("Unexpected result %+v\n", v)
+		}
+		if r, err := bp.Resolve(routing); err != nil || r.Current != routing {
+			t.Errorf("Expected %s to be current, got %+v, %v\n", routing, r, err)
+		}
+	}
+}
+func TestResolveCycle(t *testing.T) {
+	data := record("011000015", "011000028") + record("011000028", "011000138") + record("011000138", "011000015")
+	bp := &BankProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	if _, err := bp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	_, err := bp.Resolve("011000028")
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 ServiceError, got %v\n", err)
+	}
+}
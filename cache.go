@@ -0,0 +1,83 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxAge is the Cache-Control max-age of a Service that does not
+// set its own.
+const DefaultMaxAge = time.Hour
+
+// setCacheHeaders sets the ETag, Last-Modified and Cache-Control headers
+// of a search's response in format from the data set described by info,
+// and returns the ETag.
+func (s *Service) setCacheHeaders(w http.ResponseWriter, info LoadInfo, format string) (tag string) {
+	h := w.Header()
+	if info.Checksum != "" {
+		tag = etag(info.Checksum, format)
+		h.Set("ETag", tag)
+	}
+	if !info.LoadedAt.IsZero() {
+		h.Set("Last-Modified", info.LoadedAt.UTC().Format(http.TimeFormat))
+	}
+	maxAge := s.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+	if maxAge < 0 {
+		h.Set("Cache-Control", "no-cache")
+	} else {
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge/time.Second)))
+	}
+	return tag
+}
+
+// etag returns the entity tag of a response in format from the data set
+// with checksum. The response to a query depends only on the data set
+// and the format, so the tag is strong.
+func etag(checksum string, format string) string {
+	if len(checksum) > 32 {
+		checksum = checksum[:32]
+	}
+	return `"` + checksum + "-" + format + `"`
+}
+
+// notModified reports whether r is a conditional request that the
+// response with tag, from a data set loaded at modified, satisfies. As
+// in RFC 7232, If-None-Match is used when it is present, and
+// If-Modified-Since only when it is not.
+func notModified(r *http.Request, tag string, modified time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if tag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			// If-None-Match uses the weak comparison
+			if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+				return true
+			}
+		}
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// Last-Modified has a resolution of one second
+	return !modified.Truncate(time.Second).After(since)
+}
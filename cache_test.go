@@ -0,0 +1,76 @@
+package stddata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	p := &fakeProvider{data: []string{"a"}}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	w, _ := get(t, s, "/fake?name=a")
+	tag := w.Header().Get("ETag")
+	if tag == "" || tag != etag(p.Info().Checksum, "json") {
+		t.Fatalf("Unexpected ETag %s\n", tag)
+	}
+	if w.Header().Get("Cache-Control") != "public, max-age=3600" || w.Header().Get("Vary") != "Accept" {
+		t.Fatalf("Unexpected headers %v\n", w.Header())
+	}
+	modified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+
+	w, _ = get(t, s, "/fake?name=a&format=csv")
+	if w.Header().Get("ETag") == tag || w.Header().Get("Vary") != "" {
+		t.Fatalf("Unexpected headers %v\n", w.Header())
+	}
+
+	conditional := func(header string, value string) int {
+		r := httptest.NewRequest("GET", "/fake?name=a", nil)
+		r.Header.Set(header, value)
+		w, _ := do(t, s, r)
+		if w.Code == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Fatalf("Expected no body with 304, got %s\n", w.Body.String())
+		}
+		return w.Code
+	}
+	if code := conditional("If-None-Match", tag); code != http.StatusNotModified {
+		t.Fatalf("Expected 304, got %d\n", code)
+	}
+	if code := conditional("If-None-Match", `"other", W/`+tag); code != http.StatusNotModified {
+		t.Fatalf("Expected 304, got %d\n", code)
+	}
+	if code := conditional("If-None-Match", `"other"`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", code)
+	}
+	if code := conditional("If-Modified-Since", modified.Format(http.TimeFormat)); code != http.StatusNotModified {
+		t.Fatalf("Expected 304, got %d\n", code)
+	}
+	if code := conditional("If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat)); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", code)
+	}
+
+	// a reload of different data changes the ETag
+	p.data = []string{"a", "b"}
+	s.Reload()
+	if code := conditional("If-None-Match", tag); code != http.StatusOK {
+		t.Fatalf("Expected 200 after a reload, got %d\n", code)
+	}
+}
+func TestCacheMaxAge(t *testing.T) {
+	p := &fakeProvider{data: []string{"a"}}
+	s := &Service{MaxAge: -1}
+	s.LoadProvider(p, "fake")
+	w, _ := get(t, s, "/fake?name=a")
+	if w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("Unexpected Cache-Control %s\n", w.Header().Get("Cache-Control"))
+	}
+	w, _ = get(t, s, "/fake?name=a&limit=x")
+	if w.Header().Get("ETag") != "" {
+		t.Fatal("Expected no ETag on an error")
+	}
+}
@@ -1,7 +1,5 @@
 package country
 
-import "strings"
-
 /*
 countrydata is derived from the ISO 3166-1 information
 presented on wikipedia:
@@ -10,7 +8,7 @@ The data was obtained from the wiki source for "Officially
 assigned code elements". Some munging occurred, then the
 tab-delimited csv file data in this source file was constructed.
 */
-var countrydata = strings.NewReader(`Afghanistan	AF	AFG	004
+const countrydata = `Afghanistan	AF	AFG	004
 Åland Islands	AX	ALA	248
 Albania	AL	ALB	008
 Algeria	DZ	DZA	012
@@ -258,4 +256,4 @@ Wallis and Futuna	WF	WLF	876
 Western Sahara	EH	ESH	732
 Yemen	YE	YEM	887
 Zambia	ZM	ZMB	894
-Zimbabwe	ZW	ZWE	716`)
+Zimbabwe	ZW	ZWE	716`
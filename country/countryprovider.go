@@ -11,25 +11,29 @@ package country
 
 import (
 	"encoding/csv"
-	"errors"
 	"io"
 	"net/http"
-	"sort"
-	"strings"
+	"sync"
 
 	"github.com/musicbeat/stddata"
+	"github.com/musicbeat/stddata/index"
 )
 
 // CountryProvider implements the Provider interface.
 type CountryProvider struct {
+	// Source supplies the country codes. When it is nil, the data
+	// set declared in countrydata.go is used.
+	Source stddata.Source
+	// status is the lifecycle of the data.
+	status stddata.Status
+	// loadMu allows one Load at a time.
+	loadMu sync.Mutex
+	// mu guards loaded, size and the indexes, which Load replaces
+	// together, so that searches see one complete data set.
+	mu             sync.RWMutex
 	loaded         bool
 	size           int
-	countryIndexes map[string]countryIndex
-}
-
-type countryIndex struct {
-	countryMap  map[string][]Country
-	countryKeys []string
+	countryIndexes map[string]*index.Index[Country]
 }
 
 // Country models one entity.
@@ -40,26 +44,52 @@ type Country struct {
 	NumericCode string
 }
 
-// CountryResult is the interface{} that is returned from Search
+// CountryResult is the interface{} that is returned from Search. Total is the
+// number of keys that matched, of which Countries holds the requested page.
 type CountryResult struct {
+	Total     int
 	Countries [][]Country
 }
 
-var englishNameMap map[string][]Country
-var alpha2Map map[string][]Country
-var alpha3Map map[string][]Country
-var numericMap map[string][]Country
+// indexInfo describes the indexes that Load builds.
+var indexInfo = []stddata.IndexInfo{
+	{Name: "name", Key: "EnglishName", Match: index.Prefix},
+	{Name: "alpha2", Key: "Alpha2Code", Match: index.Prefix},
+	{Name: "alpha3", Key: "Alpha3Code", Match: index.Prefix},
+	{Name: "number", Key: "NumericCode", Match: index.Prefix},
+}
 
 // Load implements the Loader interface
 func (p *CountryProvider) Load() (n int, err error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	p.status.Begin()
+	defer func() { p.status.Finish(err) }()
+
 	// initialize the maps:
-	p.countryIndexes = make(map[string]countryIndex)
-	englishNameMap = make(map[string][]Country)
-	alpha2Map = make(map[string][]Country)
-	alpha3Map = make(map[string][]Country)
-	numericMap = make(map[string][]Country)
+	englishNameMap := make(map[string][]Country)
+	alpha2Map := make(map[string][]Country)
+	alpha3Map := make(map[string][]Country)
+	numericMap := make(map[string][]Country)
+
+	src := p.Source
+	if src == nil {
+		src = &stddata.EmbeddedSource{Name: "countrydata", Data: []byte(countrydata)}
+	}
+	body, err := p.status.Open(src)
+	if err == stddata.ErrNotModified {
+		// the data set that is served is current
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.size, nil
+	}
+	if err != nil {
+		msg := "Failed to retrieve " + src.String() + ". " + err.Error()
+		return 0, &stddata.ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
+	}
+	defer body.Close()
 
-	reader := csv.NewReader(countrydata)
+	reader := csv.NewReader(body)
 	reader.Comma = '\t'
 	reader.FieldsPerRecord = 4
 	reader.TrimLeadingSpace = true
@@ -71,7 +101,7 @@ func (p *CountryProvider) Load() (n int, err error) {
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+			return 0, &stddata.ServiceError{Msg: err.Error(), Code: http.StatusServiceUnavailable}
 		}
 
 		var c Country
@@ -87,30 +117,41 @@ func (p *CountryProvider) Load() (n int, err error) {
 		numericMap[c.NumericCode] = append(numericMap[c.NumericCode], c)
 
 	}
-	p.storeData("name", englishNameMap)
-	p.storeData("alpha2", alpha2Map)
-	p.storeData("alpha3", alpha3Map)
-	p.storeData("number", numericMap)
+	// replace the indexes only once the whole data set has been read
+	p.mu.Lock()
+	p.countryIndexes = map[string]*index.Index[Country]{
+		"name":   index.New(englishNameMap, index.Prefix),
+		"alpha2": index.New(alpha2Map, index.Prefix),
+		"alpha3": index.New(alpha3Map, index.Prefix),
+		"number": index.New(numericMap, index.Prefix),
+	}
 	p.size = len(englishNameMap)
 	p.loaded = true
+	p.mu.Unlock()
 	return len(englishNameMap), err
 }
 
-func (p *CountryProvider) storeData(s string, m map[string][]Country) {
-	// store the map
-	var ci countryIndex
-	ci.countryMap = m
-	// extract the keys
-	ci.countryKeys = make([]string, len(m))
-	i := 0
-	for k, _ := range m {
-		ci.countryKeys[i] = k
-		i++
-	}
-	// sort the keys
-	sort.Strings(ci.countryKeys)
-	// add to countryIndexes
-	p.countryIndexes[s] = ci
+// current returns the indexes of the most recent successful Load.
+func (p *CountryProvider) current() (loaded bool, indexes map[string]*index.Index[Country]) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.loaded, p.countryIndexes
+}
+
+// State implements the Provider interface.
+func (p *CountryProvider) State() (state stddata.State, err error) {
+	return p.status.State()
+}
+
+// LoadInfo implements the Provider interface.
+func (p *CountryProvider) LoadInfo() stddata.LoadInfo {
+	return p.status.Info()
+}
+
+// Indexes implements the Provider interface.
+func (p *CountryProvider) Indexes() []stddata.IndexInfo {
+	_, indexes := p.current()
+	return stddata.DescribeIndexes(indexInfo, indexes)
 }
 
 // Search returns a collection as an interface{} and error. The collection
@@ -121,42 +162,19 @@ func (p *CountryProvider) storeData(s string, m map[string][]Country) {
 // any matching Countries are returned in the result.
 // Search can also "dump" an index. When the value of query is "_dump", the index specified
 // is used to supply the entire data set, in the order of the index.
-func (p *CountryProvider) Search(index string, query string) (result interface{}, err error) {
+func (p *CountryProvider) Search(index string, query string, page stddata.Page) (result interface{}, err error) {
 	// make sure the data is loaded
-	if p.loaded != true {
-		return nil, errors.New("this should be a 503 Service Unavailable by the time it gets to the client")
+	loaded, indexes := p.current()
+	if loaded != true {
+		return nil, p.status.Unavailable("Country list")
 	}
-	ci, found := p.countryIndexes[index]
+	ci, found := indexes[index]
 	if !found {
 		// search cannot be performed
 		msg := "No index on " + index
-		return nil, &stddata.ServiceError{msg, http.StatusBadRequest}
+		return nil, &stddata.ServiceError{Msg: msg, Code: http.StatusBadRequest}
 	}
-	result = doSearch(ci, query)
+	countries, total := ci.SearchPage(query, page.Offset, page.Limit)
+	result = CountryResult{Total: total, Countries: countries}
 	return result, nil
 }
-func doSearch(ci countryIndex, query string) (res CountryResult) {
-	// the "reserved" query term "_dump" is handled by returning all the
-	// results in the order of the index.
-	dump := query == "_dump"
-	// prepare the response. allocate enough space for the response to be the
-	// entire data set.
-	tmp := make([][]Country, len(ci.countryKeys))
-	// brute force the sorted list of keys, looking for a match to 'query.*'.
-	// add each match to the result array. The results are added in the
-	// order of the sorted keys, so the results are sorted.
-	i := 0
-	for k := range ci.countryKeys {
-		if dump {
-			tmp[i] = ci.countryMap[ci.countryKeys[k]]
-			i++
-		} else if len(ci.countryKeys[k]) >= len(query) {
-			if strings.EqualFold(query, ci.countryKeys[k][0:len(query)]) {
-				tmp[i] = ci.countryMap[ci.countryKeys[k]]
-				i++
-			}
-		}
-	}
-	res.Countries = tmp[0:i]
-	return res
-}
@@ -3,6 +3,9 @@ package country
 // Keep reading: http://golang.org/doc/code.html#Testing
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	. "github.com/musicbeat/stddata"
@@ -22,32 +25,65 @@ func TestCountryProvider(t *testing.T) {
 		t.Fatalf("Expected to load %d, loaded %d\n", expected, n)
 	}
 }
+func TestCountryProviderLoadMalformed(t *testing.T) {
+	data := "Afghanistan\tAF\tAFG\t004\nAlbania\tAL\n"
+	cp := &CountryProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	_, err := cp.Load()
+	if err == nil {
+		t.Fatal("Expected malformed line to fail the load")
+	}
+}
+func TestCountryProviderReloadNotModified(t *testing.T) {
+	data := "Afghanistan\tAF\tAFG\t004\nAlbania\tAL\tALB\t008\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(data))
+	}))
+	defer ts.Close()
+	cp := &CountryProvider{Source: &HTTPSource{URL: ts.URL}}
+	if _, err := cp.Load(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	loaded := cp.LoadInfo()
+	n, err := cp.Load()
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 unchanged, got %d, %v\n", n, err)
+	}
+	info := cp.LoadInfo()
+	if info.LastError != nil || info.LoadedAt != loaded.LoadedAt || !info.CheckedAt.After(loaded.CheckedAt) {
+		t.Fatalf("Unexpected %+v after %+v\n", info, loaded)
+	}
+}
 func TestNameSearch(t *testing.T) {
-	_, err := p.Search("name", "A")
+	_, err := p.Search("name", "A", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
 }
 func TestNameSearchLowerCase(t *testing.T) {
-	_, err := p.Search("name", "b")
+	_, err := p.Search("name", "b", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
 }
 func TestAlpha2Search(t *testing.T) {
-	_, err := p.Search("alpha2", "C")
+	_, err := p.Search("alpha2", "C", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
 }
 func TestAlpha3Search(t *testing.T) {
-	_, err := p.Search("alpha3", "U")
+	_, err := p.Search("alpha3", "U", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
 }
 func TestNumberSearch(t *testing.T) {
-	_, err := p.Search("number", "1")
+	_, err := p.Search("number", "1", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
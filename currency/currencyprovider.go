@@ -13,22 +13,31 @@ import (
 	"encoding/xml"
 	"io/ioutil"
 	"net/http"
-	"sort"
-	"strings"
+	"sync"
 
 	"github.com/musicbeat/stddata"
+	"github.com/musicbeat/stddata/index"
 )
 
 // CurrencyProvider implements the Provider interface.
 type CurrencyProvider struct {
+	// Source supplies the currency table. When it is nil, Load sets
+	// it to an HTTPSource for currency-iso.org.
+	Source stddata.Source
+	// CacheDir, when it is not empty, is a directory in which a
+	// snapshot of the last table that loaded is kept, to be loaded
+	// when Source cannot be read. See stddata.CachedSource.
+	CacheDir string
+	// status is the lifecycle of the data.
+	status stddata.Status
+	// loadMu allows one Load at a time.
+	loadMu sync.Mutex
+	// mu guards loaded, size and the indexes, which Load replaces
+	// together, so that searches see one complete data set.
+	mu              sync.RWMutex
 	loaded          bool
 	size            int
-	currencyIndexes map[string]currencyIndex
-}
-
-type currencyIndex struct {
-	currencyMap  map[string][]Currency
-	currencyKeys []string
+	currencyIndexes map[string]*index.Index[Currency]
 }
 
 // Currency is the information on one currency in the source data.
@@ -45,44 +54,68 @@ type Currencies struct {
 	Currencies []Currency `xml:"CcyTbl>CcyNtry"`
 }
 
-// CurrencyResult is the interface{} that is returned from Search
+// CurrencyResult is the interface{} that is returned from Search. Total is the
+// number of keys that matched, of which Currencies holds the requested page.
 type CurrencyResult struct {
+	Total      int
 	Currencies [][]Currency
 }
 
-var countryNameMap map[string][]Currency
-var currencyNameMap map[string][]Currency
-var currencyCodeMap map[string][]Currency
-var currencyNumberMap map[string][]Currency
+var currencyurl = "http://www.currency-iso.org/dam/downloads/table_a1.xml"
+
+// indexInfo describes the indexes that Load builds.
+var indexInfo = []stddata.IndexInfo{
+	{Name: "country", Key: "CountryName", Match: index.Prefix},
+	{Name: "name", Key: "CurrencyName", Match: index.Prefix},
+	{Name: "code", Key: "CurrencyCode", Match: index.Prefix},
+	{Name: "number", Key: "CurrencyNumber", Match: index.Prefix},
+}
 
 // Load does the heavy lifting of retrieving the iso.org
 // web site's handy XML file. The file is retrieved and
 // parsed into structs, and loaded into maps and indexes
 // to support searches.
 func (p *CurrencyProvider) Load() (n int, err error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	p.status.Begin()
+	defer func() { p.status.Finish(err) }()
+
 	// Initialize the maps:
-	p.currencyIndexes = make(map[string]currencyIndex)
-	countryNameMap = make(map[string][]Currency)
-	currencyNameMap = make(map[string][]Currency)
-	currencyCodeMap = make(map[string][]Currency)
-	currencyNumberMap = make(map[string][]Currency)
+	countryNameMap := make(map[string][]Currency)
+	currencyNameMap := make(map[string][]Currency)
+	currencyCodeMap := make(map[string][]Currency)
+	currencyNumberMap := make(map[string][]Currency)
 
-	res, err := http.Get("http://www.currency-iso.org/dam/downloads/table_a1.xml")
+	if p.Source == nil {
+		p.Source = &stddata.HTTPSource{URL: currencyurl}
+	}
+	src := p.Source
+	if p.CacheDir != "" {
+		src = &stddata.CachedSource{Source: src, Dir: p.CacheDir}
+	}
+	body, err := p.status.Open(src)
+	if err == stddata.ErrNotModified {
+		// the data set that is served is current
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.size, nil
+	}
 	if err != nil {
-		msg := "Failed to retrieve http://www.currency-iso.org/dam/downloads/table_a1.xml " + err.Error()
-		return 0, &stddata.ServiceError{msg, http.StatusServiceUnavailable}
+		msg := "Failed to retrieve " + src.String() + " " + err.Error()
+		return 0, &stddata.ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
 	}
-	defer res.Body.Close()
+	defer body.Close()
 
-	currencyBody, err := ioutil.ReadAll(res.Body)
+	currencyBody, err := ioutil.ReadAll(body)
 	if err != nil {
-		return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+		return 0, &stddata.ServiceError{Msg: err.Error(), Code: http.StatusServiceUnavailable}
 	}
 
 	var currencies Currencies
 	err = xml.Unmarshal([]byte(currencyBody), &currencies)
 	if err != nil {
-		return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+		return 0, &stddata.ServiceError{Msg: err.Error(), Code: http.StatusServiceUnavailable}
 	}
 
 	// add the currency entities to the maps:
@@ -93,29 +126,41 @@ func (p *CurrencyProvider) Load() (n int, err error) {
 		currencyNumberMap[c.CurrencyNumber] = append(currencyNumberMap[c.CurrencyNumber], c)
 	}
 
-	p.storeData("country", countryNameMap)
-	p.storeData("name", currencyNameMap)
-	p.storeData("code", currencyCodeMap)
-	p.storeData("number", currencyNumberMap)
+	// replace the indexes only once the whole data set has been read
+	p.mu.Lock()
+	p.currencyIndexes = map[string]*index.Index[Currency]{
+		"country": index.New(countryNameMap, index.Prefix),
+		"name":    index.New(currencyNameMap, index.Prefix),
+		"code":    index.New(currencyCodeMap, index.Prefix),
+		"number":  index.New(currencyNumberMap, index.Prefix),
+	}
 	p.size = len(currencyCodeMap)
 	p.loaded = true
+	p.mu.Unlock()
 	return len(currencyCodeMap), err
 }
 
-func (p *CurrencyProvider) storeData(s string, m map[string][]Currency) {
-	// store the map
-	var ci currencyIndex
-	ci.currencyMap = m
-	// extract the keys
-	ci.currencyKeys = make([]string, len(m))
-	i := 0
-	for k, _ := range m {
-		ci.currencyKeys[i] = k
-		i++
-	}
-	// sort the keys
-	sort.Strings(ci.currencyKeys)
-	p.currencyIndexes[s] = ci
+// current returns the indexes of the most recent successful Load.
+func (p *CurrencyProvider) current() (loaded bool, indexes map[string]*index.Index[Currency]) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.loaded, p.currencyIndexes
+}
+
+// State implements the Provider interface.
+func (p *CurrencyProvider) State() (state stddata.State, err error) {
+	return p.status.State()
+}
+
+// LoadInfo implements the Provider interface.
+func (p *CurrencyProvider) LoadInfo() stddata.LoadInfo {
+	return p.status.Info()
+}
+
+// Indexes implements the Provider interface.
+func (p *CurrencyProvider) Indexes() []stddata.IndexInfo {
+	_, indexes := p.current()
+	return stddata.DescribeIndexes(indexInfo, indexes)
 }
 
 // Search returns a collection as an interface{} and error. The collection
@@ -126,42 +171,19 @@ func (p *CurrencyProvider) storeData(s string, m map[string][]Currency) {
 // any matching Currency entities are returned in the result.
 // Search can also "dump" an index. When the value of query is "_dump", the index specified
 // is used to supply the entire data set, in the order of the index.
-func (p *CurrencyProvider) Search(index string, query string) (result interface{}, err error) {
+func (p *CurrencyProvider) Search(index string, query string, page stddata.Page) (result interface{}, err error) {
 	// make sure the data is loaded
-	if p.loaded != true {
-		return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+	loaded, indexes := p.current()
+	if loaded != true {
+		return nil, p.status.Unavailable("Currency table")
 	}
-	ci, found := p.currencyIndexes[index]
+	ci, found := indexes[index]
 	if !found {
 		// search cannot be performed
 		msg := "No index on " + index
-		return nil, &stddata.ServiceError{msg, http.StatusBadRequest}
+		return nil, &stddata.ServiceError{Msg: msg, Code: http.StatusBadRequest}
 	}
-	result = doSearch(ci, query)
+	currencies, total := ci.SearchPage(query, page.Offset, page.Limit)
+	result = CurrencyResult{Total: total, Currencies: currencies}
 	return result, nil
 }
-func doSearch(ci currencyIndex, query string) (res CurrencyResult) {
-	// the "reserved" query term "_dump" is handled by returning all the
-	// results in the order of the index.
-	dump := query == "_dump"
-	// prepare the response. allocate enough space for the response to be the
-	// entire data set.
-	tmp := make([][]Currency, len(ci.currencyKeys))
-	// brute force the sorted list of keys, looking for a match to 'query.*'.
-	// add each match to the result array. The results are added in the
-	// order of the sorted keys, so the results are sorted.
-	i := 0
-	for k := range ci.currencyKeys {
-		if dump {
-			tmp[i] = ci.currencyMap[ci.currencyKeys[k]]
-			i++
-		} else if len(ci.currencyKeys[k]) >= len(query) {
-			if strings.EqualFold(query, ci.currencyKeys[k][0:len(query)]) {
-				tmp[i] = ci.currencyMap[ci.currencyKeys[k]]
-				i++
-			}
-		}
-	}
-	res.Currencies = tmp[0:i]
-	return res
-}
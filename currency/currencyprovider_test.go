@@ -2,63 +2,110 @@ package currency
 
 // Keep reading: http://golang.org/doc/code.html#Testing
 import (
-	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	. "github.com/musicbeat/stddata"
 )
 
 var p Provider
+
+// number of currency codes in testdata/table_a1.xml, a recorded
+// snapshot of currency-iso.org's table.
 var expected int = 180
 
+// fixture stands in for currency-iso.org, serving the files in testdata.
+func fixture() *httptest.Server {
+	return httptest.NewServer(http.FileServer(http.Dir("testdata")))
+}
+
 func TestCurrencyProviderLoad(t *testing.T) {
-	fmt.Println("Test: CurrencyProvider.Load")
-	p = new(CurrencyProvider)
+	ts := fixture()
+	defer ts.Close()
+	p = &CurrencyProvider{Source: &HTTPSource{URL: ts.URL + "/table_a1.xml"}}
 	n, err := p.Load()
 	if err != nil {
-		t.Fatal()
+		t.Fatalf("Err %v\n", err)
 	}
 	if n != expected {
 		t.Fatalf("Expected to load %d, loaded %d\n", expected, n)
 	}
 }
+func TestCurrencyProviderLoadTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/table_a1.xml")
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	cp := &CurrencyProvider{Source: &EmbeddedSource{Data: data[:len(data)/2]}}
+	_, err = cp.Load()
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 ServiceError, got %v\n", err)
+	}
+}
+func TestCurrencyProviderLoadMalformed(t *testing.T) {
+	data := []byte("<ISO_4217><CcyTbl><CcyNtry><Ccy>USD</CcyNtry></CcyTbl></ISO_4217>")
+	cp := &CurrencyProvider{Source: &EmbeddedSource{Data: data}}
+	_, err := cp.Load()
+	if err == nil {
+		t.Fatal("Expected malformed xml to fail the load")
+	}
+}
 func TestCountrySearch(t *testing.T) {
-	matches, err := p.Search("country", "A")
+	matches, err := p.Search("country", "A", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(CurrencyResult).Currencies); n != 15 {
+		t.Fatalf("Expected 15 countries, got %d\n", n)
+	}
 }
 func TestNameSearch(t *testing.T) {
-	matches, err := p.Search("name", "A")
+	matches, err := p.Search("name", "A", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(CurrencyResult).Currencies); n != 8 {
+		t.Fatalf("Expected 8 names, got %d\n", n)
+	}
 }
 func TestNameSearchLowerCase(t *testing.T) {
-	matches, err := p.Search("name", "a")
+	matches, err := p.Search("name", "a", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(CurrencyResult).Currencies); n != 8 {
+		t.Fatalf("Expected 8 names, got %d\n", n)
+	}
 }
 func TestCodeSearch(t *testing.T) {
-	matches, err := p.Search("code", "E")
+	matches, err := p.Search("code", "EUR", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	currencies := matches.(CurrencyResult).Currencies
+	if len(currencies) != 1 || len(currencies[0]) != 34 {
+		t.Fatalf("Unexpected EUR result %v\n", currencies)
+	}
+	if c := currencies[0][0]; c.CurrencyNumber != "978" || c.MinorUnits != "2" {
+		t.Fatalf("Unexpected currency %+v\n", c)
+	}
 }
 func TestNumberSearch(t *testing.T) {
-	matches, err := p.Search("number", "0")
+	matches, err := p.Search("number", "0", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(CurrencyResult).Currencies); n != 16 {
+		t.Fatalf("Expected 16 numbers, got %d\n", n)
+	}
 }
 func BenchmarkNameSearch(b *testing.B) {
-	p = new(CurrencyProvider)
+	ts := fixture()
+	defer ts.Close()
+	p = &CurrencyProvider{Source: &HTTPSource{URL: ts.URL + "/table_a1.xml"}}
 	n, err := p.Load()
 	if err != nil {
 		b.Fatal()
@@ -67,11 +114,23 @@ func BenchmarkNameSearch(b *testing.B) {
 		b.Fatalf("Expected to load %d, loaded %d\n", expected, n)
 	}
 	b.ResetTimer()
-	fmt.Printf("start runs...")
 	for i := 0; i < b.N; i++ {
-		_, err := p.Search("name", "a")
+		_, err := p.Search("name", "a", Page{})
 		if err != nil {
 			b.Fatalf("Err %v\n", err)
 		}
 	}
 }
+func TestCurrencyServiceXML(t *testing.T) {
+	s := &Service{Provider: p, EntityName: "currency"}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/currency?code=USD&format=xml", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/xml") {
+		t.Fatalf("Unexpected response %d %s\n", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"<CurrencyResult>", "<Currency>", "<Ccy>USD</Ccy>", "<CcyNm>US Dollar</CcyNm>"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("Expected %s in %s\n", want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package fedwire implements the methods of a stddata.Provider.
+It provides searches against the data set retrieved from
+the Federal Reserve Fedwire Funds Service participant directory.
+*/
+package fedwire
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/musicbeat/stddata"
+	"github.com/musicbeat/stddata/index"
+)
+
+// FedwireProvider implements the Provider interface.
+type FedwireProvider struct {
+	// Source supplies the directory. When it is nil, Load sets it to
+	// an HTTPSource for the Fed's website.
+	Source stddata.Source
+	// CacheDir, when it is not empty, is a directory in which a
+	// snapshot of the last directory that loaded is kept, to be loaded
+	// when Source cannot be read. See stddata.CachedSource.
+	CacheDir string
+	// status is the lifecycle of the data.
+	status stddata.Status
+	// loadMu allows one Load at a time.
+	loadMu sync.Mutex
+	// mu guards loaded, size and the indexes, which Load replaces
+	// together, so that searches see one complete data set.
+	mu                 sync.RWMutex
+	loaded             bool
+	size               int
+	participantIndexes map[string]*index.Index[Participant]
+}
+
+// Participant is the information on one Fedwire Funds Service
+// participant in the source data.
+type Participant struct {
+	Routing                           string // Length 9; Columns 1-9
+	TelegraphicName                   string // Length 18; Columns 10-27
+	CustomerName                      string // Length 36; Columns 28-63
+	StateCode                         string // Length 2; Columns 64-65
+	City                              string // Length 25; Columns 66-90
+	FundsTransferStatus               string // Length 1; Column 91
+	FundsSettlementOnlyStatus         string // Length 1; Column 92
+	BookEntrySecuritiesTransferStatus string // Length 1; Column 93
+	DateOfLastRevision                string // Length 8; Columns 94-101
+}
+
+// FedwireResult is the interface{} that is returned from Search. Total is the
+// number of keys that matched, of which Participants holds the requested page.
+type FedwireResult struct {
+	Total        int
+	Participants [][]Participant
+}
+
+// FundsTransferEligible reports whether the participant can send and
+// receive Fedwire funds transfers.
+func (f Participant) FundsTransferEligible() bool {
+	return f.FundsTransferStatus == "Y"
+}
+
+// SettlementOnly reports whether the participant is restricted to
+// settlement-only funds transfers.
+func (f Participant) SettlementOnly() bool {
+	return f.FundsSettlementOnlyStatus == "S"
+}
+
+// BookEntryEligible reports whether the participant can transfer
+// book-entry securities.
+func (f Participant) BookEntryEligible() bool {
+	return f.BookEntrySecuritiesTransferStatus == "Y"
+}
+
+// Column map:
+var rn = [...]int{0, 9}
+var tn = [...]int{9, 27}
+var cn = [...]int{27, 63}
+var sc = [...]int{63, 65}
+var ci = [...]int{65, 90}
+var ft = [...]int{90, 91}
+var fs = [...]int{91, 92}
+var be = [...]int{92, 93}
+var dt = [...]int{93, 101}
+
+// recordLength is the length of a record whose revision date is
+// blank and has been trimmed.
+const recordLength = 93
+
+var fedurl = "http://www.fededirectory.frb.org/fpddir.txt"
+
+// indexInfo describes the indexes that Load builds.
+var indexInfo = []stddata.IndexInfo{
+	{Name: "number", Key: "Routing", Match: index.Prefix},
+	{Name: "telegraphic", Key: "TelegraphicName", Match: index.Prefix},
+	{Name: "name", Key: "CustomerName", Match: index.Prefix},
+	{Name: "transfer", Key: "CustomerName", Match: index.Prefix},
+	{Name: "settlement", Key: "CustomerName", Match: index.Prefix},
+	{Name: "bookentry", Key: "CustomerName", Match: index.Prefix},
+}
+
+// Load does the heavy lifting of retrieving the Fed's directory
+// of Fedwire participants, a fixed format text file served via
+// http, and populating maps for searches.
+//
+// Besides the number, telegraphic and name indexes, Load builds the
+// filtered indexes transfer, settlement and bookentry. These are
+// keyed on customer name, like the name index, but hold only the
+// participants that are funds transfer eligible, settlement-only,
+// or book-entry securities transfer eligible.
+func (p *FedwireProvider) Load() (n int, err error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	p.status.Begin()
+	defer func() { p.status.Finish(err) }()
+
+	// Initialize the maps:
+	routingNumberMap := make(map[string][]Participant)
+	telegraphicNameMap := make(map[string][]Participant)
+	customerNameMap := make(map[string][]Participant)
+	transferMap := make(map[string][]Participant)
+	settlementMap := make(map[string][]Participant)
+	bookEntryMap := make(map[string][]Participant)
+
+	if p.Source == nil {
+		p.Source = &stddata.HTTPSource{URL: fedurl}
+	}
+	src := p.Source
+	if p.CacheDir != "" {
+		src = &stddata.CachedSource{Source: src, Dir: p.CacheDir}
+	}
+	body, err := p.status.Open(src)
+	if err == stddata.ErrNotModified {
+		// the data set that is served is current
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.size, nil
+	}
+	if err != nil {
+		msg := "Failed to retrieve " + src.String() + ". " + err.Error()
+		return 0, &stddata.ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
+	}
+	defer body.Close()
+
+	bio := bufio.NewReader(body)
+	for lineno := 1; ; lineno++ {
+		var f Participant
+		line, err := bio.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return 0, &stddata.ServiceError{Msg: err.Error(), Code: http.StatusServiceUnavailable}
+		}
+		sline := strings.TrimRight(string(line), "\r\n")
+		if len(sline) == 0 {
+			continue
+		}
+		if len(sline) < recordLength {
+			msg := fmt.Sprintf("Malformed record at line %d of %s", lineno, src)
+			return 0, &stddata.ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
+		}
+		// the revision date is often blank, and may have been trimmed
+		sline = fmt.Sprintf("%-*s", dt[1], sline)
+
+		f.Routing = strings.TrimSpace(sline[rn[0]:rn[1]])
+		f.TelegraphicName = strings.TrimSpace(sline[tn[0]:tn[1]])
+		f.CustomerName = strings.TrimSpace(sline[cn[0]:cn[1]])
+		f.StateCode = strings.TrimSpace(sline[sc[0]:sc[1]])
+		f.City = strings.TrimSpace(sline[ci[0]:ci[1]])
+		f.FundsTransferStatus = strings.TrimSpace(sline[ft[0]:ft[1]])
+		f.FundsSettlementOnlyStatus = strings.TrimSpace(sline[fs[0]:fs[1]])
+		f.BookEntrySecuritiesTransferStatus = strings.TrimSpace(sline[be[0]:be[1]])
+		f.DateOfLastRevision = strings.TrimSpace(sline[dt[0]:dt[1]])
+
+		// add the Participant to the maps:
+		routingNumberMap[f.Routing] = append(routingNumberMap[f.Routing], f)
+		telegraphicNameMap[f.TelegraphicName] = append(telegraphicNameMap[f.TelegraphicName], f)
+		customerNameMap[f.CustomerName] = append(customerNameMap[f.CustomerName], f)
+		if f.FundsTransferEligible() {
+			transferMap[f.CustomerName] = append(transferMap[f.CustomerName], f)
+		}
+		if f.SettlementOnly() {
+			settlementMap[f.CustomerName] = append(settlementMap[f.CustomerName], f)
+		}
+		if f.BookEntryEligible() {
+			bookEntryMap[f.CustomerName] = append(bookEntryMap[f.CustomerName], f)
+		}
+	}
+	// replace the indexes only once the whole data set has been read
+	p.mu.Lock()
+	p.participantIndexes = map[string]*index.Index[Participant]{
+		"number":      index.New(routingNumberMap, index.Prefix),
+		"telegraphic": index.New(telegraphicNameMap, index.Prefix),
+		"name":        index.New(customerNameMap, index.Prefix),
+		"transfer":    index.New(transferMap, index.Prefix),
+		"settlement":  index.New(settlementMap, index.Prefix),
+		"bookentry":   index.New(bookEntryMap, index.Prefix),
+	}
+	p.size = len(routingNumberMap)
+	p.loaded = true
+	p.mu.Unlock()
+	return len(routingNumberMap), nil
+}
+
+// current returns the indexes of the most recent successful Load.
+func (p *FedwireProvider) current() (loaded bool, indexes map[string]*index.Index[Participant]) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.loaded, p.participantIndexes
+}
+
+// State implements the Provider interface.
+func (p *FedwireProvider) State() (state stddata.State, err error) {
+	return p.status.State()
+}
+
+// LoadInfo implements the Provider interface.
+func (p *FedwireProvider) LoadInfo() stddata.LoadInfo {
+	return p.status.Info()
+}
+
+// Indexes implements the Provider interface.
+func (p *FedwireProvider) Indexes() []stddata.IndexInfo {
+	_, indexes := p.current()
+	return stddata.DescribeIndexes(indexInfo, indexes)
+}
+
+// Search returns a collection as an interface{} and error. The collection
+// contains an array of the results to the search. The value
+// in index is used to choose the map of Participant entities that will be searched.
+// If the value in index does not match the name of a map, an error is returned.
+// The keys in the map specified by index are searched using a regex-like 'query.*', and
+// any matching Participants are returned in the result.
+// Search can also "dump" an index. When the value of query is "_dump", the index specified
+// is used to supply the entire data set, in the order of the index.
+func (p *FedwireProvider) Search(index string, query string, page stddata.Page) (result interface{}, err error) {
+	// make sure the data is loaded
+	loaded, indexes := p.current()
+	if loaded != true {
+		return nil, p.status.Unavailable("Fedwire directory")
+	}
+	pi, found := indexes[index]
+	if !found {
+		// search cannot be performed
+		msg := "No index on " + index
+		return nil, &stddata.ServiceError{Msg: msg, Code: http.StatusBadRequest}
+	}
+	participants, total := pi.SearchPage(query, page.Offset, page.Limit)
+	result = FedwireResult{Total: total, Participants: participants}
+	return result, nil
+}
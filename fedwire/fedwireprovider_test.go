@@ -0,0 +1,102 @@
+package fedwire
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/musicbeat/stddata"
+)
+
+var p Provider
+
+// number of participants in testdata/fpddir.txt, a recorded snapshot
+// of the Fed's directory.
+var expected = 160
+
+// fixture stands in for the Fed's website, serving the files in testdata.
+func fixture() *httptest.Server {
+	return httptest.NewServer(http.FileServer(http.Dir("testdata")))
+}
+
+func TestFedwireProviderLoad(t *testing.T) {
+	ts := fixture()
+	defer ts.Close()
+	p = &FedwireProvider{Source: &HTTPSource{URL: ts.URL + "/fpddir.txt"}}
+	n, err := p.Load()
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n != expected {
+		t.Fatalf("Expected to load %d, loaded %d\n", expected, n)
+	}
+}
+func TestFedwireProviderLoadMalformed(t *testing.T) {
+	data := "011000015FRB-BOS           FEDERAL RESERVE BANK OF BOSTON\n"
+	fp := &FedwireProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	_, err := fp.Load()
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 ServiceError, got %v\n", err)
+	}
+}
+func TestNumberSearch(t *testing.T) {
+	matches, err := p.Search("number", "011000015", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	participants := matches.(FedwireResult).Participants
+	if len(participants) != 1 {
+		t.Fatalf("Expected 1 number, got %d\n", len(participants))
+	}
+	f := participants[0][0]
+	if f.TelegraphicName != "FRB-BOS" || f.CustomerName != "FEDERAL RESERVE BANK OF BOSTON" ||
+		f.StateCode != "MA" || f.City != "BOSTON" || f.DateOfLastRevision != "20040910" {
+		t.Fatalf("Unexpected participant %+v\n", f)
+	}
+	if !f.FundsTransferEligible() || f.SettlementOnly() || !f.BookEntryEligible() {
+		t.Fatalf("Unexpected eligibility %+v\n", f)
+	}
+}
+func TestTelegraphicSearch(t *testing.T) {
+	matches, err := p.Search("telegraphic", "frb-bos", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n := len(matches.(FedwireResult).Participants); n != 1 {
+		t.Fatalf("Expected 1 name, got %d\n", n)
+	}
+}
+func TestNameSearch(t *testing.T) {
+	matches, err := p.Search("name", "state street", Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n := len(matches.(FedwireResult).Participants); n == 0 {
+		t.Fatal("Expected names, got none")
+	}
+}
+func TestFilteredIndexes(t *testing.T) {
+	eligible := map[string]func(Participant) bool{
+		"transfer":   Participant.FundsTransferEligible,
+		"settlement": Participant.SettlementOnly,
+		"bookentry":  Participant.BookEntryEligible,
+	}
+	for index, ok := range eligible {
+		matches, err := p.Search(index, "_dump", Page{})
+		if err != nil {
+			t.Fatalf("Err %v\n", err)
+		}
+		participants := matches.(FedwireResult).Participants
+		if len(participants) == 0 {
+			t.Fatalf("Expected participants in %s, got none\n", index)
+		}
+		for _, pp := range participants {
+			for _, f := range pp {
+				if !ok(f) {
+					t.Fatalf("Unexpected participant in %s: %+v\n", index, f)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,474 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// formats are the values that the format parameter may take, with the
+// Content-Type of each.
+var formats = map[string]string{
+	"json":   "application/json",
+	"csv":    "text/csv; charset=utf-8",
+	"xml":    "application/xml; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// mediaTypes maps the media types of an Accept header to formats.
+var mediaTypes = map[string]string{
+	"application/json":     "json",
+	"application/*":        "json",
+	"*/*":                  "json",
+	"text/csv":             "csv",
+	"application/xml":      "xml",
+	"text/xml":             "xml",
+	"application/x-ndjson": "ndjson",
+	"application/ndjson":   "ndjson",
+}
+
+// negotiate returns the format that an Accept header prefers. Without
+// an Accept header, the format is json. An Accept header that accepts
+// none of the formats gets a ServiceError with http.StatusNotAcceptable.
+func negotiate(accept string) (format string, err error) {
+	if strings.TrimSpace(accept) == "" {
+		return "json", nil
+	}
+	best := 0.0
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(item)
+		if err != nil {
+			continue
+		}
+		f, found := mediaTypes[mediaType]
+		if !found {
+			continue
+		}
+		q := 1.0
+		if v, found := params["q"]; found {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			format, best = f, q
+		}
+	}
+	if format == "" {
+		msg := "No supported format is acceptable to " + accept + "; the formats are json, csv, xml and ndjson"
+		return "", &ServiceError{Msg: msg, Code: http.StatusNotAcceptable}
+	}
+	return format, nil
+}
+
+// encode writes res to w in format, keeping only the named fields of
+// each record when fields is not nil. The records are encoded one at a
+// time, so that a large result is written as it is encoded rather
+// than built in memory first.
+func encode(w io.Writer, format string, res interface{}, fields []string) error {
+	switch format {
+	case "csv":
+		return encodeCSV(w, res, fields)
+	case "xml":
+		return encodeXML(w, res)
+	case "ndjson":
+		return encodeNDJSON(w, res, fields)
+	}
+	return encodeJSON(w, res, fields)
+}
+
+// eachRecord calls fn with each entity in a search's result, in order.
+// By convention a result is a struct whose entities are in a slice of
+// slices, as in BankResult; the slices are flattened. A result that is
+// a slice holds the entities itself. Any other result is one record.
+func eachRecord(res interface{}, fn func(rec reflect.Value) error) error {
+	v := reflect.Indirect(reflect.ValueOf(res))
+	switch v.Kind() {
+	case reflect.Slice:
+		return eachElement(v, fn)
+	case reflect.Struct:
+		if i := groupsField(v.Type()); i >= 0 {
+			return eachElement(v.Field(i), fn)
+		}
+	}
+	return fn(v)
+}
+
+// recordType returns the type of the entities in a search's result.
+func recordType(res interface{}) reflect.Type {
+	t := reflect.Indirect(reflect.ValueOf(res)).Type()
+	switch t.Kind() {
+	case reflect.Slice:
+		return elementType(t)
+	case reflect.Struct:
+		if i := groupsField(t); i >= 0 {
+			return elementType(t.Field(i).Type)
+		}
+	}
+	return t
+}
+
+// groupsField returns the index of the first field of t that is a slice
+// of slices, or -1.
+func groupsField(t reflect.Type) int {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i).Type
+		if f.Kind() == reflect.Slice && f.Elem().Kind() == reflect.Slice {
+			return i
+		}
+	}
+	return -1
+}
+
+// eachElement calls fn with each element of the slice v, flattening
+// slices of slices.
+func eachElement(v reflect.Value, fn func(e reflect.Value) error) error {
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		var err error
+		if e.Kind() == reflect.Slice {
+			err = eachElement(e, fn)
+		} else {
+			err = fn(e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// elementType returns the type of the elements of the slice type t,
+// flattening slices of slices.
+func elementType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+// column is a column of a CSV response: a field of the records, or the
+// record itself when the records are not structs.
+type column struct {
+	name  string
+	index int // the index of the field, or -1 for the record itself
+}
+
+// columns returns the columns of records of type t, in the order of the
+// fields of t, or in the order of fields when it is not nil. Field
+// names are matched without regard to case.
+func columns(t reflect.Type, fields []string) []column {
+	if t.Kind() != reflect.Struct {
+		return []column{{name: "Value", index: -1}}
+	}
+	var all []column
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Name == "XMLName" {
+			continue
+		}
+		all = append(all, column{name: f.Name, index: i})
+	}
+	if fields == nil {
+		return all
+	}
+	var cols []column
+	for _, name := range fields {
+		for _, c := range all {
+			if strings.EqualFold(c.name, name) {
+				cols = append(cols, c)
+			}
+		}
+	}
+	return cols
+}
+
+// checkFields returns a ServiceError with http.StatusBadRequest that
+// names the first of fields that the records of res do not have. In
+// CSV a field is a column of the records; in json it is a key of the
+// records as they are marshaled, which may have keys of their own, as
+// a Bank with its Decoded fields does.
+func checkFields(format string, res interface{}, fields []string) error {
+	known := make(map[string]bool)
+	if format == "csv" {
+		for _, c := range columns(recordType(res), nil) {
+			known[strings.ToLower(c.name)] = true
+		}
+	} else {
+		fieldNames(reflect.TypeOf(res), known, make(map[reflect.Type]bool))
+	}
+	marshaled := format == "csv"
+	for _, name := range fields {
+		if !known[strings.ToLower(name)] && !marshaled {
+			// only marshal the records when their types fall short
+			if err := recordKeys(res, known); err != nil {
+				return err
+			}
+			marshaled = true
+		}
+		if !known[strings.ToLower(name)] {
+			return badRequest("Parameter fields has unknown field " + name)
+		}
+	}
+	return nil
+}
+
+// fieldNames adds the folded json keys of the exported fields of the
+// structs in t to names.
+func fieldNames(t reflect.Type, names map[string]bool, seen map[reflect.Type]bool) {
+	if t == nil || seen[t] {
+		return
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		fieldNames(t.Elem(), names, seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.PkgPath != "" || key == "-" {
+				continue
+			}
+			if key == "" {
+				key = f.Name
+			}
+			names[strings.ToLower(key)] = true
+			fieldNames(f.Type, names, seen)
+		}
+	}
+}
+
+// recordKeys adds the folded keys of the records of res, as project
+// sees them in its json, to names.
+func recordKeys(res interface{}, names map[string]bool) error {
+	j, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(j, &tree); err != nil {
+		return err
+	}
+	treeKeys(tree, names, false)
+	return nil
+}
+
+// treeKeys walks tree as projectTree does, adding the keys of the
+// objects that are results to names.
+func treeKeys(tree interface{}, names map[string]bool, inArray bool) {
+	switch t := tree.(type) {
+	case []interface{}:
+		for _, v := range t {
+			treeKeys(v, names, true)
+		}
+	case map[string]interface{}:
+		for k, v := range t {
+			if inArray {
+				names[strings.ToLower(k)] = true
+				continue
+			}
+			treeKeys(v, names, false)
+		}
+	}
+}
+
+// encodeCSV writes the records of res as CSV, with a header row of the
+// names of the columns.
+func encodeCSV(w io.Writer, res interface{}, fields []string) error {
+	cols := columns(recordType(res), fields)
+	cw := csv.NewWriter(w)
+	row := make([]string, len(cols))
+	for i, c := range cols {
+		row[i] = c.name
+	}
+	cw.Write(row)
+	err := eachRecord(res, func(rec reflect.Value) error {
+		for i, c := range cols {
+			v := rec
+			if c.index >= 0 {
+				v = rec.Field(c.index)
+			}
+			cell, err := cell(v)
+			if err != nil {
+				return err
+			}
+			row[i] = cell
+		}
+		return cw.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// cell returns the text of one CSV cell. Values that are not strings,
+// booleans or numbers are written as json.
+func cell(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface()), nil
+	}
+	j, err := json.Marshal(v.Interface())
+	return string(j), err
+}
+
+// encodeNDJSON writes the records of res as json, one per line.
+func encodeNDJSON(w io.Writer, res interface{}, fields []string) error {
+	return eachRecord(res, func(rec reflect.Value) error {
+		v := rec.Interface()
+		if fields != nil {
+			var err error
+			if v, err = projectRecord(v, fields); err != nil {
+				return err
+			}
+		}
+		j, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(j, '\n'))
+		return err
+	})
+}
+
+// encodeJSON writes res as indented json. A result that follows the
+// convention of a struct with a slice of slices, and no json tags, is
+// written one group of entities at a time, in the same form that
+// json.MarshalIndent gives it. Any other result is marshaled whole.
+func encodeJSON(w io.Writer, res interface{}, fields []string) (err error) {
+	v := reflect.Indirect(reflect.ValueOf(res))
+	if v.Kind() != reflect.Struct || groupsField(v.Type()) < 0 || hasTags(v.Type()) {
+		j, err := marshalIndent(res, fields, "")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(j, '\n'))
+		return err
+	}
+	io.WriteString(w, "{")
+	sep := "\n"
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		io.WriteString(w, sep+"  \""+f.Name+"\": ")
+		sep = ",\n"
+		if i != groupsField(v.Type()) {
+			j, err := marshalIndent(v.Field(i).Interface(), fields, "  ")
+			if err != nil {
+				return err
+			}
+			w.Write(j)
+			continue
+		}
+		groups := v.Field(i)
+		if groups.Len() == 0 {
+			io.WriteString(w, "[]")
+			continue
+		}
+		io.WriteString(w, "[")
+		for g := 0; g < groups.Len(); g++ {
+			j, err := marshalIndent(groups.Index(g).Interface(), fields, "    ")
+			if err != nil {
+				return err
+			}
+			if g > 0 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, "\n    ")
+			if _, err := w.Write(j); err != nil {
+				return err
+			}
+		}
+		io.WriteString(w, "\n  ]")
+	}
+	_, err = io.WriteString(w, "\n}\n")
+	return err
+}
+
+// marshalIndent returns the indented json of v, keeping only the named
+// fields of each result when fields is not nil.
+func marshalIndent(v interface{}, fields []string, prefix string) (j []byte, err error) {
+	if fields != nil {
+		if v, err = project(v, fields); err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(v, prefix, "  ")
+}
+
+// hasTags reports whether any field of t has a json tag.
+func hasTags(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if _, found := t.Field(i).Tag.Lookup("json"); found {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeXML writes res as XML. The root element is named for the type
+// of the result, and each record is an element named for its type.
+func encodeXML(w io.Writer, res interface{}) error {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	v := reflect.Indirect(reflect.ValueOf(res))
+	if v.Kind() != reflect.Struct {
+		if err := enc.EncodeElement(res, element("Result")); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	root := element(v.Type().Name())
+	enc.EncodeToken(root)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if i != groupsField(v.Type()) {
+			if err := enc.EncodeElement(v.Field(i).Interface(), element(f.Name)); err != nil {
+				return err
+			}
+			continue
+		}
+		enc.EncodeToken(element(f.Name))
+		err := eachElement(v.Field(i), func(rec reflect.Value) error {
+			return enc.EncodeElement(rec.Interface(), element(rec.Type().Name()))
+		})
+		if err != nil {
+			return err
+		}
+		enc.EncodeToken(element(f.Name).End())
+	}
+	enc.EncodeToken(root.End())
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func element(name string) xml.StartElement {
+	return xml.StartElement{Name: xml.Name{Local: name}}
+}
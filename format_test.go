@@ -0,0 +1,171 @@
+package stddata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeEntity struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+type fakeResult struct {
+	Total    int
+	Entities [][]fakeEntity
+}
+
+var result = fakeResult{
+	Total: 2,
+	Entities: [][]fakeEntity{
+		{{Name: "a, b", Count: 1, Tags: []string{"x"}}},
+		{{Name: "c", Count: 2}},
+	},
+}
+
+func encodeString(format string, res interface{}, fields []string) (string, error) {
+	var b strings.Builder
+	err := encode(&b, format, res, fields)
+	return b.String(), err
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct{ accept, want string }{
+		{"", "json"},
+		{"*/*", "json"},
+		{"text/csv", "csv"},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", "xml"},
+		{"application/json;q=0.5, application/x-ndjson", "ndjson"},
+	}
+	for _, c := range cases {
+		got, err := negotiate(c.accept)
+		if err != nil || got != c.want {
+			t.Errorf("%s: Expected %s, got %s, %v\n", c.accept, c.want, got, err)
+		}
+	}
+	_, err := negotiate("text/html")
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusNotAcceptable {
+		t.Fatalf("Expected 406 ServiceError, got %v\n", err)
+	}
+}
+func TestEncodeCSV(t *testing.T) {
+	b, err := encodeString("csv", result, nil)
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	want := "Name,Count,Tags\n\"a, b\",1,\"[\"\"x\"\"]\"\nc,2,null\n"
+	if b != want {
+		t.Fatalf("Expected %q, got %q\n", want, b)
+	}
+	b, _ = encodeString("csv", result, []string{"count", "name"})
+	if b != "Count,Name\n1,\"a, b\"\n2,c\n" {
+		t.Fatalf("Unexpected CSV %q\n", b)
+	}
+}
+func TestEncodeNDJSON(t *testing.T) {
+	b, err := encodeString("ndjson", result, []string{"name"})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if b != "{\"Name\":\"a, b\"}\n{\"Name\":\"c\"}\n" {
+		t.Fatalf("Unexpected NDJSON %q\n", b)
+	}
+}
+func TestEncodeXML(t *testing.T) {
+	b, err := encodeString("xml", result, nil)
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	for _, want := range []string{"<fakeResult>", "<Total>2</Total>", "<Entities>", "<fakeEntity>", "<Name>a, b</Name>", "<Tags>x</Tags>"} {
+		if !strings.Contains(b, want) {
+			t.Fatalf("Expected %s in %s\n", want, b)
+		}
+	}
+}
+func TestServiceFormat(t *testing.T) {
+	p := &fakeProvider{data: []string{"a", "b"}}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	w, _ := get(t, s, "/fake?name=a&format=csv")
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" || w.Body.String() != "Value\na\nb\n" {
+		t.Fatalf("Unexpected response %s %q\n", w.Header().Get("Content-Type"), w.Body.String())
+	}
+	r := httptest.NewRequest("GET", "/fake?name=a", nil)
+	r.Header.Set("Accept", "application/pdf")
+	w, e := do(t, s, r)
+	if w.Code != http.StatusNotAcceptable || e.Code != http.StatusNotAcceptable {
+		t.Fatalf("Expected 406, got %d %+v\n", w.Code, e)
+	}
+}
+func TestServiceFields(t *testing.T) {
+	p := &fakeProvider{data: []string{"a"}, result: result}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	w, _ := get(t, s, "/fake?name=a&format=csv&fields=count,name")
+	if w.Code != http.StatusOK || w.Body.String() != "Count,Name\n1,\"a, b\"\n2,c\n" {
+		t.Fatalf("Unexpected response %d %q\n", w.Code, w.Body.String())
+	}
+	cases := []struct {
+		url, accept, msg string
+	}{
+		{"/fake?name=a&format=xml&fields=Name", "", "format xml"},
+		{"/fake?name=a&fields=Name", "application/xml", "format xml"},
+		{"/fake?name=a&format=csv&fields=Name,City", "", "unknown field City"},
+		{"/fake?name=a&fields=Color", "", "unknown field Color"},
+		{"/fake?name=a&format=ndjson&fields=Color", "", "unknown field Color"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.url, nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w, e := do(t, s, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(e.Message, c.msg) {
+			t.Errorf("%s: Expected 400 naming %s, got %d %+v\n", c.url, c.msg, w.Code, e)
+		}
+	}
+}
+func TestEncodeJSON(t *testing.T) {
+	j, _ := json.MarshalIndent(result, "", "  ")
+	got, err := encodeString("json", result, nil)
+	if err != nil || got != string(j)+"\n" {
+		t.Fatalf("Expected\n%s\ngot\n%s %v\n", j, got, err)
+	}
+	// projected objects have their keys sorted, so compare the values
+	want, _ := project(result, []string{"name"})
+	got, err = encodeString("json", result, []string{"name"})
+	var v interface{}
+	if err := json.Unmarshal([]byte(got), &v); err != nil || !reflect.DeepEqual(v, want) {
+		t.Fatalf("Expected %v, got %s %v\n", want, got, err)
+	}
+	empty, _ := encodeString("json", fakeResult{}, nil)
+	if empty != "{\n  \"Total\": 0,\n  \"Entities\": []\n}\n" {
+		t.Fatalf("Unexpected json %q\n", empty)
+	}
+}
+func TestServiceStream(t *testing.T) {
+	entities := make([][]fakeEntity, 1000)
+	for i := range entities {
+		entities[i] = []fakeEntity{{Name: "e", Count: i}}
+	}
+	p := &fakeProvider{data: []string{"a"}, result: fakeResult{Total: len(entities), Entities: entities}}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	w, _ := get(t, s, "/fake?name=_dump&format=ndjson")
+	if !w.Flushed {
+		t.Fatal("Expected the response to be flushed")
+	}
+	if n := strings.Count(w.Body.String(), "\n"); n != 1000 {
+		t.Fatalf("Expected 1000 lines, got %d\n", n)
+	}
+	w, _ = get(t, s, "/fake?name=_dump")
+	var res fakeResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Total != 1000 || res.Entities[999][0].Count != 999 {
+		t.Fatalf("Unexpected result %d %v\n", res.Total, err)
+	}
+}
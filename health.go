@@ -0,0 +1,116 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"net/http"
+	"time"
+)
+
+// Meta is the json body that describes the data set a Service serves.
+type Meta struct {
+	Provider     string     `json:"provider"`
+	State        State      `json:"state"`
+	Count        int        `json:"count"`
+	Source       string     `json:"source,omitempty"`
+	Checksum     string     `json:"checksum,omitempty"`
+	LoadedAt     *time.Time `json:"loadedAt,omitempty"`
+	LoadDuration string     `json:"loadDuration,omitempty"`
+	CheckedAt    *time.Time `json:"checkedAt,omitempty"`
+	Stale        bool       `json:"stale,omitempty"`
+	SnapshotAt   *time.Time `json:"snapshotAt,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+// Meta describes the data set that the Service serves.
+func (s *Service) Meta() Meta {
+	info := s.Provider.LoadInfo()
+	m := Meta{
+		Provider: s.EntityName,
+		State:    info.State,
+		Count:    s.count(),
+		Source:   info.Source,
+		Checksum: info.Checksum,
+		Stale:    info.Stale,
+	}
+	if !info.LoadedAt.IsZero() {
+		m.LoadedAt = &info.LoadedAt
+		m.LoadDuration = info.Duration.String()
+		m.CheckedAt = &info.CheckedAt
+	}
+	if info.Stale {
+		m.SnapshotAt = &info.SnapshotAt
+	}
+	if info.LastError != nil {
+		m.LastError = info.LastError.Error()
+	}
+	return m
+}
+
+// MetaHandler returns an http.Handler that responds with the Meta of
+// the Service.
+func (s *Service) MetaHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Meta())
+	})
+}
+
+// Health is the json body of the responses to liveness and readiness
+// probes.
+type Health struct {
+	Status    string           `json:"status"`
+	Providers map[string]State `json:"providers,omitempty"`
+}
+
+// HealthHandler returns an http.Handler that answers a liveness probe.
+// A Service that can respond is live, whatever the state of its
+// Provider.
+func (s *Service) HealthHandler() http.Handler {
+	return http.HandlerFunc(writeHealth)
+}
+
+// ReadyHandler returns an http.Handler that answers a readiness probe,
+// with 200 OK when the Provider is Ready, and 503 Service Unavailable
+// when it is not.
+func (s *Service) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReady(w, map[string]*Service{s.EntityName: s})
+	})
+}
+
+// serveHealth responds to a liveness probe. A Mux that can respond is
+// live, whatever the state of its Providers.
+func (m *Mux) serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r)
+}
+
+// serveReady responds to a readiness probe, with 200 OK when every
+// Provider is Ready, and 503 Service Unavailable when any is not.
+func (m *Mux) serveReady(w http.ResponseWriter, r *http.Request) {
+	services := make(map[string]*Service)
+	for _, name := range m.names() {
+		services[name], _ = m.Service(name)
+	}
+	writeReady(w, services)
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Health{Status: "ok"})
+}
+
+// writeReady writes the readiness of services, by name.
+func writeReady(w http.ResponseWriter, services map[string]*Service) {
+	h := Health{Status: "ready", Providers: make(map[string]State)}
+	code := http.StatusOK
+	for name, s := range services {
+		state, _ := s.Provider.State()
+		h.Providers[name] = state
+		if state != Ready {
+			h.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, h)
+}
@@ -0,0 +1,89 @@
+package stddata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	w, _ := get(t, newMux(), "/healthz")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", w.Code)
+	}
+}
+func TestReadyz(t *testing.T) {
+	w, _ := get(t, newMux(), "/readyz")
+	var h Health
+	if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if w.Code != http.StatusServiceUnavailable || h.Status != "not ready" || h.Providers["down"] != Failed {
+		t.Fatalf("Unexpected readiness %d %+v\n", w.Code, h)
+	}
+
+	m := NewMux()
+	m.Register("fake", &fakeProvider{data: []string{"a"}})
+	w, _ = get(t, m, "/readyz")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s\n", w.Code, w.Body.String())
+	}
+}
+func TestServiceProbes(t *testing.T) {
+	s := &Service{Provider: &fakeProvider{}, EntityName: "fake"}
+	if w, _ := get(t, s.HealthHandler(), "/healthz"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", w.Code)
+	}
+	w, _ := get(t, s.ReadyHandler(), "/readyz")
+	var h Health
+	if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if w.Code != http.StatusServiceUnavailable || h.Providers["fake"] != NotLoaded {
+		t.Fatalf("Unexpected readiness %d %+v\n", w.Code, h)
+	}
+	s.LoadProvider(&fakeProvider{data: []string{"a"}}, "fake")
+	if w, _ := get(t, s.ReadyHandler(), "/readyz"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s\n", w.Code, w.Body.String())
+	}
+	// and through a Mux
+	if w, _ := get(t, newMux(), "/down/_readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d\n", w.Code)
+	}
+	if w, _ := get(t, newMux(), "/fake/_readyz"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", w.Code)
+	}
+}
+func TestMeta(t *testing.T) {
+	w, _ := get(t, newMux(), "/fake/_meta")
+	var meta Meta
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	sum := sha256.Sum256([]byte("a\nb"))
+	if meta.Provider != "fake" || meta.State != Ready || meta.Count != 2 || meta.Source != "fake" {
+		t.Fatalf("Unexpected meta %+v\n", meta)
+	}
+	if meta.Checksum != hex.EncodeToString(sum[:]) || meta.LoadedAt == nil || meta.LoadDuration == "" {
+		t.Fatalf("Unexpected meta %+v\n", meta)
+	}
+
+	w, _ = get(t, newMux(), "/down/_meta")
+	meta = Meta{}
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if meta.State != Failed || meta.LastError != "upstream is down" || meta.LoadedAt != nil {
+		t.Fatalf("Unexpected meta %+v\n", meta)
+	}
+}
+func TestMuxReservedName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic")
+		}
+	}()
+	NewMux().Handle("healthz", new(Service))
+}
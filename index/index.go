@@ -0,0 +1,202 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package index implements the searchable indexes that the stddata
+providers build over their data sets. An Index maps string keys to
+the entities that share the key, and keeps the keys sorted so that
+searches return entities in the order of the index.
+*/
+package index
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Match is the way an Index compares a query to its keys. All
+// matches ignore case.
+type Match int
+
+// Values of Match:
+const (
+	Prefix   Match = iota // keys that begin with the query, like 'query.*'
+	Exact                 // keys that equal the query
+	Suffix                // keys that end with the query, like '.*query'
+	Contains              // keys that contain the query, like '.*query.*'
+)
+
+func (m Match) String() string {
+	switch m {
+	case Prefix:
+		return "prefix"
+	case Exact:
+		return "exact"
+	case Suffix:
+		return "suffix"
+	case Contains:
+		return "contains"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m Match) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *Match) UnmarshalText(text []byte) error {
+	for _, match := range []Match{Prefix, Exact, Suffix, Contains} {
+		if match.String() == string(text) {
+			*m = match
+			return nil
+		}
+	}
+	return errors.New("index: unknown match " + string(text))
+}
+
+// Dump is the "reserved" query term that returns every entity in
+// the index, in the order of the index.
+const Dump = "_dump"
+
+// Index is a map of keys to entities of type T, with sorted keys.
+// The keys are sorted by their case-folded form, which is kept in
+// folded, so that Prefix and Exact searches can use a binary search.
+type Index[T any] struct {
+	match  Match
+	m      map[string][]T
+	keys   []string
+	folded []string
+}
+
+// New returns an Index over m that uses match to compare queries
+// with keys. The Index takes ownership of m.
+func New[T any](m map[string][]T, match Match) *Index[T] {
+	ix := &Index[T]{match: match, m: m}
+	// extract the keys, with their folded forms
+	type key struct{ k, f string }
+	pairs := make([]key, 0, len(m))
+	for k := range m {
+		pairs = append(pairs, key{k, fold(k)})
+	}
+	// sort the keys by their folded form, then by the keys themselves
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].f != pairs[j].f {
+			return pairs[i].f < pairs[j].f
+		}
+		return pairs[i].k < pairs[j].k
+	})
+	ix.keys = make([]string, len(pairs))
+	ix.folded = make([]string, len(pairs))
+	for i, pair := range pairs {
+		ix.keys[i], ix.folded[i] = pair.k, pair.f
+	}
+	return ix
+}
+
+// fold returns the form of s that is used to compare keys and queries
+// without regard to case.
+func fold(s string) string {
+	return strings.ToLower(s)
+}
+
+// Match returns the way the Index compares queries with keys.
+func (ix *Index[T]) Match() Match {
+	return ix.match
+}
+
+// Len returns the number of keys in the Index.
+func (ix *Index[T]) Len() int {
+	return len(ix.keys)
+}
+
+// Get returns the entities with exactly the key k.
+func (ix *Index[T]) Get(k string) (v []T, found bool) {
+	v, found = ix.m[k]
+	return v, found
+}
+
+// Search returns the entities whose keys match query, grouped by key
+// in the order of the index. When query is Dump, every entity is
+// returned.
+func (ix *Index[T]) Search(query string) [][]T {
+	res, _ := ix.SearchPage(query, 0, 0)
+	return res
+}
+
+// SearchPage is like Search, but skips the first offset groups of
+// results and returns at most limit groups. A limit of 0 means no
+// limit. It also returns the total number of groups that match.
+func (ix *Index[T]) SearchPage(query string, offset int, limit int) (res [][]T, total int) {
+	if query == Dump {
+		return ix.window(0, len(ix.keys), offset, limit), len(ix.keys)
+	}
+	q := fold(query)
+	switch ix.match {
+	case Exact:
+		lo, hi := ix.span(q, func(k string) bool { return k == q })
+		return ix.window(lo, hi, offset, limit), hi - lo
+	case Suffix:
+		return ix.scan(offset, limit, func(k string) bool { return strings.HasSuffix(k, q) })
+	case Contains:
+		return ix.scan(offset, limit, func(k string) bool { return strings.Contains(k, q) })
+	}
+	lo, hi := ix.span(q, func(k string) bool { return strings.HasPrefix(k, q) })
+	return ix.window(lo, hi, offset, limit), hi - lo
+}
+
+// span returns the run of folded keys, starting at the first key not
+// less than q, for which in is true. Because the folded keys are
+// sorted, the start of the run is found in O(log n).
+func (ix *Index[T]) span(q string, in func(k string) bool) (lo int, hi int) {
+	lo = sort.SearchStrings(ix.folded, q)
+	hi = lo
+	for hi < len(ix.folded) && in(ix.folded[hi]) {
+		hi++
+	}
+	return lo, hi
+}
+
+// window returns the entities of the keys from lo to hi, less the
+// first offset, and at most limit of them. The result is allocated
+// to the size of the window.
+func (ix *Index[T]) window(lo int, hi int, offset int, limit int) [][]T {
+	// clamped before it is added, so that a huge offset cannot overflow
+	if offset > hi-lo {
+		offset = hi - lo
+	}
+	lo += offset
+	if limit > 0 && hi-lo > limit {
+		hi = lo + limit
+	}
+	res := make([][]T, hi-lo)
+	for i := lo; i < hi; i++ {
+		res[i-lo] = ix.m[ix.keys[i]]
+	}
+	return res
+}
+
+// scan returns the page of entities of the folded keys for which in
+// is true, and the number of those keys.
+func (ix *Index[T]) scan(offset int, limit int, in func(k string) bool) (res [][]T, total int) {
+	res = make([][]T, 0)
+	for i, k := range ix.folded {
+		if !in(k) {
+			continue
+		}
+		if total >= offset && (limit == 0 || len(res) < limit) {
+			res = append(res, ix.m[ix.keys[i]])
+		}
+		total++
+	}
+	return res, total
+}
+
+// Dump returns every entity in the Index, grouped by key in the order
+// of the index.
+func (ix *Index[T]) Dump() [][]T {
+	return ix.window(0, len(ix.keys), 0, 0)
+}
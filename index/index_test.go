@@ -0,0 +1,110 @@
+package index
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func fixture(match Match) *Index[string] {
+	return New(map[string][]string{
+		"Abc":  {"abc1", "abc2"},
+		"abd":  {"abd"},
+		"Bcd":  {"bcd"},
+		"xabc": {"xabc"},
+	}, match)
+}
+
+func TestPrefix(t *testing.T) {
+	got := fixture(Prefix).Search("AB")
+	want := [][]string{{"abc1", "abc2"}, {"abd"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v\n", want, got)
+	}
+}
+func TestExact(t *testing.T) {
+	got := fixture(Exact).Search("abc")
+	want := [][]string{{"abc1", "abc2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v\n", want, got)
+	}
+}
+func TestSuffix(t *testing.T) {
+	got := fixture(Suffix).Search("BC")
+	want := [][]string{{"abc1", "abc2"}, {"xabc"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v\n", want, got)
+	}
+}
+func TestContains(t *testing.T) {
+	got := fixture(Contains).Search("bC")
+	want := [][]string{{"abc1", "abc2"}, {"bcd"}, {"xabc"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v\n", want, got)
+	}
+}
+func TestNoMatch(t *testing.T) {
+	got := fixture(Prefix).Search("zzz")
+	if got == nil || len(got) != 0 {
+		t.Fatalf("Expected an empty result, got %v\n", got)
+	}
+}
+func TestDump(t *testing.T) {
+	ix := fixture(Exact)
+	got := ix.Search(Dump)
+	if len(got) != ix.Len() || got[0][0] != "abc1" || got[3][0] != "xabc" {
+		t.Fatalf("Unexpected dump %v\n", got)
+	}
+}
+func TestGet(t *testing.T) {
+	ix := fixture(Prefix)
+	if v, found := ix.Get("abd"); !found || v[0] != "abd" {
+		t.Fatalf("Unexpected Get %v, %v\n", v, found)
+	}
+	if _, found := ix.Get("ab"); found {
+		t.Fatal("Expected Get to require the whole key")
+	}
+}
+func BenchmarkPrefix(b *testing.B) {
+	m := make(map[string][]int)
+	for i := 0; i < 20000; i++ {
+		k := fmt.Sprintf("BANK %05d", i)
+		m[k] = []int{i}
+	}
+	ix := New(m, Prefix)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if res := ix.Search("bank 0012"); len(res) != 10 {
+			b.Fatalf("Expected 10 matches, got %d\n", len(res))
+		}
+	}
+}
+func TestSearchPage(t *testing.T) {
+	ix := fixture(Prefix)
+	got, total := ix.SearchPage("ab", 1, 5)
+	if total != 2 || !reflect.DeepEqual(got, [][]string{{"abd"}}) {
+		t.Fatalf("Unexpected page %v of %d\n", got, total)
+	}
+	got, total = ix.SearchPage(Dump, 1, 2)
+	if total != 4 || !reflect.DeepEqual(got, [][]string{{"abd"}, {"bcd"}}) {
+		t.Fatalf("Unexpected page %v of %d\n", got, total)
+	}
+	got, total = ix.SearchPage("ab", 10, 0)
+	if total != 2 || len(got) != 0 {
+		t.Fatalf("Unexpected page %v of %d\n", got, total)
+	}
+	// a span that does not start at the first key
+	got, total = ix.SearchPage("bc", math.MaxInt, 0)
+	if total != 1 || len(got) != 0 {
+		t.Fatalf("Unexpected page %v of %d\n", got, total)
+	}
+	got, total = fixture(Exact).SearchPage("bcd", math.MaxInt, math.MaxInt)
+	if total != 1 || len(got) != 0 {
+		t.Fatalf("Unexpected page %v of %d\n", got, total)
+	}
+	got, total = fixture(Contains).SearchPage("bc", 1, 1)
+	if total != 3 || !reflect.DeepEqual(got, [][]string{{"bcd"}}) {
+		t.Fatalf("Unexpected page %v of %d\n", got, total)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/musicbeat/stddata/index"
+)
+
+// IndexInfo describes an index that a Provider's Search accepts.
+type IndexInfo struct {
+	Name  string      `json:"name"`  // the value of the index parameter
+	Key   string      `json:"key"`   // the field of the entities that keys the index
+	Match index.Match `json:"match"` // the way queries are compared with keys
+	// Cardinality is the number of distinct keys in the index. It is
+	// 0 until the Provider has loaded, and for lookups, like a
+	// validation, that are not backed by an index.
+	Cardinality int `json:"cardinality"`
+}
+
+// IndexList is the json body that describes a Provider's indexes.
+type IndexList struct {
+	Provider string      `json:"provider"`
+	Indexes  []IndexInfo `json:"indexes"`
+}
+
+// DescribeIndexes returns a copy of info, sorted by name, with the
+// Cardinality of each index that is found in indexes. Providers use it
+// to implement Indexes.
+func DescribeIndexes[T any](info []IndexInfo, indexes map[string]*index.Index[T]) []IndexInfo {
+	res := make([]IndexInfo, len(info))
+	copy(res, info)
+	for i := range res {
+		if ix, found := indexes[res[i].Name]; found {
+			res[i].Cardinality = ix.Len()
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// IndexesHandler returns an http.Handler that responds with the
+// IndexList of the Provider.
+func (s *Service) IndexesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, IndexList{Provider: s.EntityName, Indexes: s.Provider.Indexes()})
+	})
+}
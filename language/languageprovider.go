@@ -25,22 +25,32 @@ import (
 	"encoding/csv"
 	"io"
 	"net/http"
-	"sort"
 	"strings"
+	"sync"
 
 	"github.com/musicbeat/stddata"
+	"github.com/musicbeat/stddata/index"
 )
 
 // LanguageProvider implements the Provider interfaces.
 type LanguageProvider struct {
+	// Source supplies the language list. When it is nil, Load sets
+	// it to an HTTPSource for the Library of Congress' website.
+	Source stddata.Source
+	// CacheDir, when it is not empty, is a directory in which a
+	// snapshot of the last list that loaded is kept, to be loaded
+	// when Source cannot be read. See stddata.CachedSource.
+	CacheDir string
+	// status is the lifecycle of the data.
+	status stddata.Status
+	// loadMu allows one Load at a time.
+	loadMu sync.Mutex
+	// mu guards loaded, size and the indexes, which Load replaces
+	// together, so that searches see one complete data set.
+	mu              sync.RWMutex
 	loaded          bool
 	size            int
-	languageIndexes map[string]languageIndex
-}
-
-type languageIndex struct {
-	languageMap  map[string][]Language
-	languageKeys []string
+	languageIndexes map[string]*index.Index[Language]
 }
 
 // Language is the information on one language in the source data
@@ -52,34 +62,59 @@ type Language struct {
 	FrenchName          string
 }
 
-// LanguageResult is the interface{} that is returned from Search
+// LanguageResult is the interface{} that is returned from Search. Total is the
+// number of keys that matched, of which Languages holds the requested page.
 type LanguageResult struct {
+	Total     int
 	Languages [][]Language
 }
 
-var alphaMap map[string][]Language
-var englishNameMap map[string][]Language
+var languageurl = "http://www.loc.gov/standards/iso639-2/ISO-639-2_utf-8.txt"
+
+// indexInfo describes the indexes that Load builds.
+var indexInfo = []stddata.IndexInfo{
+	{Name: "alpha", Key: "Alpha3bibliographic", Match: index.Prefix},
+	{Name: "name", Key: "EnglishName", Match: index.Prefix},
+}
 
 // Load does the heavy lifting of retrieving the
 // Library of Congress' list of languages, a pipe-delimited
 // .csv file, and populating maps for searching.
 func (p *LanguageProvider) Load() (n int, err error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	p.status.Begin()
+	defer func() { p.status.Finish(err) }()
+
 	// initialize the maps:
-	p.languageIndexes = make(map[string]languageIndex)
-	alphaMap = make(map[string][]Language)
-	englishNameMap = make(map[string][]Language)
+	alphaMap := make(map[string][]Language)
+	englishNameMap := make(map[string][]Language)
 
-	res, err := http.Get("http://www.loc.gov/standards/iso639-2/ISO-639-2_utf-8.txt")
+	if p.Source == nil {
+		p.Source = &stddata.HTTPSource{URL: languageurl}
+	}
+	src := p.Source
+	if p.CacheDir != "" {
+		src = &stddata.CachedSource{Source: src, Dir: p.CacheDir}
+	}
+	body, err := p.status.Open(src)
+	if err == stddata.ErrNotModified {
+		// the data set that is served is current
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.size, nil
+	}
 	if err != nil {
-		return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+		msg := "Failed to retrieve " + src.String() + ". " + err.Error()
+		return 0, &stddata.ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
 	}
 
-	reader := csv.NewReader(res.Body)
+	reader := csv.NewReader(body)
 	reader.Comma = '|'
 	reader.FieldsPerRecord = 5
 	reader.TrimLeadingSpace = true
 
-	defer res.Body.Close()
+	defer body.Close()
 
 	for {
 		// read just one record
@@ -88,9 +123,12 @@ func (p *LanguageProvider) Load() (n int, err error) {
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return 0, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+			return 0, &stddata.ServiceError{Msg: err.Error(), Code: http.StatusServiceUnavailable}
 		}
 
+		// the Library of Congress' file begins with a byte order mark
+		record[0] = strings.TrimPrefix(record[0], "\ufeff")
+
 		var l Language
 		l.Alpha3bibliographic = record[0]
 		l.Alpha3terminologic = record[1]
@@ -103,28 +141,39 @@ func (p *LanguageProvider) Load() (n int, err error) {
 		englishNameMap[l.EnglishName] = append(englishNameMap[l.EnglishName], l)
 
 	}
-	p.storeData("alpha", alphaMap)
-	p.storeData("name", englishNameMap)
+	// replace the indexes only once the whole data set has been read
+	p.mu.Lock()
+	p.languageIndexes = map[string]*index.Index[Language]{
+		"alpha": index.New(alphaMap, index.Prefix),
+		"name":  index.New(englishNameMap, index.Prefix),
+	}
 	p.size = len(alphaMap)
 	p.loaded = true
+	p.mu.Unlock()
 	return len(alphaMap), err
 }
 
-func (p *LanguageProvider) storeData(s string, m map[string][]Language) {
-	// store the map
-	var li languageIndex
-	li.languageMap = m
-	// extract the keys
-	li.languageKeys = make([]string, len(m))
-	i := 0
-	for k, _ := range m {
-		li.languageKeys[i] = k
-		i++
-	}
-	// sort the keys
-	sort.Strings(li.languageKeys)
-	// add to languageIndexes
-	p.languageIndexes[s] = li
+// current returns the indexes of the most recent successful Load.
+func (p *LanguageProvider) current() (loaded bool, indexes map[string]*index.Index[Language]) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.loaded, p.languageIndexes
+}
+
+// State implements the Provider interface.
+func (p *LanguageProvider) State() (state stddata.State, err error) {
+	return p.status.State()
+}
+
+// LoadInfo implements the Provider interface.
+func (p *LanguageProvider) LoadInfo() stddata.LoadInfo {
+	return p.status.Info()
+}
+
+// Indexes implements the Provider interface.
+func (p *LanguageProvider) Indexes() []stddata.IndexInfo {
+	_, indexes := p.current()
+	return stddata.DescribeIndexes(indexInfo, indexes)
 }
 
 // Search returns a collection as an interface{} and error. The collection
@@ -135,42 +184,19 @@ func (p *LanguageProvider) storeData(s string, m map[string][]Language) {
 // any matching Languages are returned in the result.
 // Search can also "dump" an index. When the value of query is "_dump", the index specified
 // is used to supply the entire data set, in the order of the index.
-func (p *LanguageProvider) Search(index string, query string) (result interface{}, err error) {
+func (p *LanguageProvider) Search(index string, query string, page stddata.Page) (result interface{}, err error) {
 	// make sure the data is loaded
-	if p.loaded != true {
-		return nil, &stddata.ServiceError{err.Error(), http.StatusServiceUnavailable}
+	loaded, indexes := p.current()
+	if loaded != true {
+		return nil, p.status.Unavailable("Language list")
 	}
-	li, found := p.languageIndexes[index]
+	li, found := indexes[index]
 	if !found {
 		// search cannot be performed
 		msg := "No index on " + index
-		return nil, &stddata.ServiceError{msg, http.StatusBadRequest}
+		return nil, &stddata.ServiceError{Msg: msg, Code: http.StatusBadRequest}
 	}
-	result = doSearch(li, query)
+	languages, total := li.SearchPage(query, page.Offset, page.Limit)
+	result = LanguageResult{Total: total, Languages: languages}
 	return result, nil
 }
-func doSearch(li languageIndex, query string) (res LanguageResult) {
-	// the "reserved" query term "_dump" is handled by returning all the
-	// results in the order of the index.
-	dump := query == "_dump"
-	// prepare the response. allocate enough space for the response to be the
-	// entire data set.
-	tmp := make([][]Language, len(li.languageKeys))
-	// brute force the sorted list of keys, looking for a match to 'query.*'.
-	// add each match to the result array. The results are added in the
-	// order of the sorted keys, so the results are sorted.
-	i := 0
-	for k := range li.languageKeys {
-		if dump {
-			tmp[i] = li.languageMap[li.languageKeys[k]]
-			i++
-		} else if len(li.languageKeys[k]) >= len(query) {
-			if strings.EqualFold(query, li.languageKeys[k][0:len(query)]) {
-				tmp[i] = li.languageMap[li.languageKeys[k]]
-				i++
-			}
-		}
-	}
-	res.Languages = tmp[0:i]
-	return res
-}
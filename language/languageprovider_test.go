@@ -2,49 +2,92 @@ package language
 
 // Keep reading: http://golang.org/doc/code.html#Testing
 import (
-	"fmt"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	. "github.com/musicbeat/stddata"
 )
 
 var p Provider
+
+// number of alpha codes in testdata/ISO-639-2_utf-8.txt, a recorded
+// snapshot of the Library of Congress' list.
 var expected int = 486
 
+// fixture stands in for loc.gov, serving the files in testdata.
+func fixture() *httptest.Server {
+	return httptest.NewServer(http.FileServer(http.Dir("testdata")))
+}
+
 func TestLanguageProviderLoad(t *testing.T) {
-	fmt.Println("Test: LanguageProvider.Load")
-	p = new(LanguageProvider)
+	ts := fixture()
+	defer ts.Close()
+	p = &LanguageProvider{Source: &HTTPSource{URL: ts.URL + "/ISO-639-2_utf-8.txt"}}
 	n, err := p.Load()
 	if err != nil {
-		t.Fatal()
+		t.Fatalf("Err %v\n", err)
 	}
 	if n != expected {
 		t.Fatalf("Expected to load %d, loaded %d\n", expected, n)
 	}
 }
+func TestLanguageProviderLoadMalformed(t *testing.T) {
+	data := "aar||aa|Afar|afar\nabk|ab|Abkhazian\n"
+	lp := &LanguageProvider{Source: &ReaderSource{Reader: strings.NewReader(data)}}
+	_, err := lp.Load()
+	if serr, ok := err.(*ServiceError); !ok || serr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 ServiceError, got %v\n", err)
+	}
+}
+func TestLanguageProviderLoadTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ISO-639-2_utf-8.txt")
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	// cut the snapshot off in the middle of a line
+	cut := bytes.Index(data, []byte("Asturian"))
+	lp := &LanguageProvider{Source: &EmbeddedSource{Data: data[:cut]}}
+	_, err = lp.Load()
+	if err == nil {
+		t.Fatal("Expected truncated file to fail the load")
+	}
+}
 func TestAlphaSearch(t *testing.T) {
-	matches, err := p.Search("alpha", "fr")
+	matches, err := p.Search("alpha", "aar", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	languages := matches.(LanguageResult).Languages
+	if len(languages) != 1 || languages[0][0].EnglishName != "Afar" {
+		t.Fatalf("Unexpected aar result %v\n", languages)
+	}
 }
 func TestNameSearch(t *testing.T) {
-	matches, err := p.Search("name", "an")
+	matches, err := p.Search("name", "an", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(LanguageResult).Languages); n != 1 {
+		t.Fatalf("Expected 1 names, got %d\n", n)
+	}
 }
 func TestNameSearchLowerCase(t *testing.T) {
-	matches, err := p.Search("name", "en")
+	matches, err := p.Search("name", "en", Page{})
 	if err != nil {
 		t.Fatalf("Err %v\n", err)
 	}
-	fmt.Println("matches %s\n", matches)
+	if n := len(matches.(LanguageResult).Languages); n != 3 {
+		t.Fatalf("Expected 3 names, got %d\n", n)
+	}
 }
 func BenchmarkNameSearch(b *testing.B) {
-	p = new(LanguageProvider)
+	ts := fixture()
+	defer ts.Close()
+	p = &LanguageProvider{Source: &HTTPSource{URL: ts.URL + "/ISO-639-2_utf-8.txt"}}
 	n, err := p.Load()
 	if err != nil {
 		b.Fatal()
@@ -53,9 +96,8 @@ func BenchmarkNameSearch(b *testing.B) {
 		b.Fatalf("Expected to load %d, loaded %d\n", expected, n)
 	}
 	b.ResetTimer()
-	fmt.Printf("start runs...")
 	for i := 0; i < b.N; i++ {
-		_, err := p.Search("name", "a")
+		_, err := p.Search("name", "a", Page{})
 		if err != nil {
 			b.Fatalf("Err %v\n", err)
 		}
@@ -0,0 +1,270 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics counts the searches and loads of Services, and writes them in
+// the Prometheus text exposition format. A Service records into its
+// Metrics when it has one; a Mux gives one Metrics to all its Services
+// and serves it at /metrics.
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]int
+	latency  map[searchKey]*histogram
+	sizes    map[searchKey]*histogram
+	loads    map[loadKey]int
+}
+
+type searchKey struct{ provider, index string }
+
+type requestKey struct {
+	searchKey
+	code int
+}
+
+type loadKey struct{ provider, outcome string }
+
+// Buckets of the latency and result size histograms:
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+var sizeBuckets = []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000, 10000}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: make(map[requestKey]int),
+		latency:  make(map[searchKey]*histogram),
+		sizes:    make(map[searchKey]*histogram),
+		loads:    make(map[loadKey]int),
+	}
+}
+
+// observeSearch records a search of index of provider that was
+// answered with code after d. A search that succeeded has a result.
+func (m *Metrics) observeSearch(provider string, index string, code int, d time.Duration, result interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := searchKey{provider, index}
+	m.requests[requestKey{k, code}]++
+	observe(m.latency, latencyBuckets, k, d.Seconds())
+	if result != nil {
+		observe(m.sizes, sizeBuckets, k, float64(resultSize(result)))
+	}
+}
+
+// observeLoad records a load of provider, which failed if err is not nil.
+func (m *Metrics) observeLoad(provider string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loads[loadKey{provider, outcome}]++
+}
+
+// resultSize returns the number of results in a search's result: the
+// length of the result when it is a slice, or of its groups of
+// entities when it is a struct that has them, as BankResult does. Any
+// other result, like a ResolveResult, is one result, whatever slices
+// it holds.
+func resultSize(result interface{}) int {
+	v := reflect.Indirect(reflect.ValueOf(result))
+	switch v.Kind() {
+	case reflect.Slice:
+		return v.Len()
+	case reflect.Struct:
+		if i := groupsField(v.Type()); i >= 0 {
+			return v.Field(i).Len()
+		}
+	}
+	return 1
+}
+
+// Handler returns an http.Handler that serves the Metrics, along with
+// the record count, readiness, data set age and staleness of each of
+// services.
+func (m *Metrics) Handler(services ...*Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serve(w, services)
+	})
+}
+
+func (m *Metrics) serve(w http.ResponseWriter, services []*Service) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	writeGauges(bw, services)
+	bw.Flush()
+}
+
+// write writes the counters and histograms.
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "stddata_requests_total", "counter", "Searches served, by provider, index and status code.")
+	requests := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		requests = append(requests, k)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].searchKey != requests[j].searchKey {
+			return requests[i].searchKey.less(requests[j].searchKey)
+		}
+		return requests[i].code < requests[j].code
+	})
+	for _, k := range requests {
+		fmt.Fprintf(w, "stddata_requests_total{%s,code=\"%d\"} %d\n", k.labels(), k.code, m.requests[k])
+	}
+
+	header(w, "stddata_request_duration_seconds", "histogram", "Latency of searches, by provider and index.")
+	writeHistograms(w, "stddata_request_duration_seconds", m.latency)
+	header(w, "stddata_result_size", "histogram", "Results returned by successful searches, by provider and index.")
+	writeHistograms(w, "stddata_result_size", m.sizes)
+
+	header(w, "stddata_loads_total", "counter", "Loads of the data sets, by provider and outcome.")
+	loads := make([]loadKey, 0, len(m.loads))
+	for k := range m.loads {
+		loads = append(loads, k)
+	}
+	sort.Slice(loads, func(i, j int) bool {
+		if loads[i].provider != loads[j].provider {
+			return loads[i].provider < loads[j].provider
+		}
+		return loads[i].outcome < loads[j].outcome
+	})
+	for _, k := range loads {
+		fmt.Fprintf(w, "stddata_loads_total{provider=\"%s\",outcome=\"%s\"} %d\n", escape(k.provider), k.outcome, m.loads[k])
+	}
+}
+
+// writeGauges writes the gauges that are read from services at the
+// time of the scrape.
+func writeGauges(w io.Writer, services []*Service) {
+	now := time.Now()
+	header(w, "stddata_records", "gauge", "Records in the data set that is served.")
+	for _, s := range services {
+		fmt.Fprintf(w, "stddata_records{provider=\"%s\"} %d\n", escape(s.EntityName), s.count())
+	}
+	header(w, "stddata_ready", "gauge", "Whether the provider is ready to serve searches.")
+	for _, s := range services {
+		ready := 0
+		if state, _ := s.Provider.State(); state == Ready {
+			ready = 1
+		}
+		fmt.Fprintf(w, "stddata_ready{provider=\"%s\"} %d\n", escape(s.EntityName), ready)
+	}
+	header(w, "stddata_dataset_age_seconds", "gauge", "Time since the data set that is served was loaded.")
+	for _, s := range services {
+		if info := s.Provider.LoadInfo(); !info.LoadedAt.IsZero() {
+			fmt.Fprintf(w, "stddata_dataset_age_seconds{provider=\"%s\"} %s\n", escape(s.EntityName), number(now.Sub(info.LoadedAt).Seconds()))
+		}
+	}
+	header(w, "stddata_stale", "gauge", "Whether the data set that is served was read from a snapshot because its source failed.")
+	for _, s := range services {
+		stale := 0
+		if s.Provider.LoadInfo().Stale {
+			stale = 1
+		}
+		fmt.Fprintf(w, "stddata_stale{provider=\"%s\"} %d\n", escape(s.EntityName), stale)
+	}
+}
+
+func header(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (k searchKey) less(o searchKey) bool {
+	if k.provider != o.provider {
+		return k.provider < o.provider
+	}
+	return k.index < o.index
+}
+
+func (k searchKey) labels() string {
+	return fmt.Sprintf("provider=\"%s\",index=\"%s\"", escape(k.provider), escape(k.index))
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	buckets []float64
+	counts  []int // counts[i] is the number of observations <= buckets[i]
+	count   int
+	sum     float64
+}
+
+func observe(hs map[searchKey]*histogram, buckets []float64, k searchKey, v float64) {
+	h, found := hs[k]
+	if !found {
+		h = &histogram{buckets: buckets, counts: make([]int, len(buckets))}
+		hs[k] = h
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func writeHistograms(w io.Writer, name string, hs map[searchKey]*histogram) {
+	keys := make([]searchKey, 0, len(hs))
+	for k := range hs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	for _, k := range keys {
+		h := hs[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, k.labels(), number(b), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, k.labels(), h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, k.labels(), number(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, k.labels(), h.count)
+	}
+}
+
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value.
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher, so that a recorded response can still
+// be streamed.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
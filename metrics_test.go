@@ -0,0 +1,76 @@
+package stddata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newMux()
+	get(t, m, "/fake?name=a")
+	get(t, m, "/fake?name=b")
+	get(t, m, "/fake?city=a")
+	get(t, m, "/down?name=a")
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fake?limit=x", nil))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Unexpected response %d %s\n", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`stddata_requests_total{provider="fake",index="name",code="200"} 2`,
+		`stddata_requests_total{provider="fake",index="unknown",code="200"} 1`,
+		`stddata_requests_total{provider="fake",index="unknown",code="400"} 1`,
+		`stddata_requests_total{provider="down",index="name",code="503"} 1`,
+		`stddata_request_duration_seconds_count{provider="fake",index="name"} 2`,
+		`stddata_result_size_bucket{provider="fake",index="name",le="1"} 0`,
+		`stddata_result_size_bucket{provider="fake",index="name",le="5"} 2`,
+		`stddata_result_size_sum{provider="fake",index="name"} 4`,
+		`stddata_loads_total{provider="down",outcome="failure"} 1`,
+		`stddata_loads_total{provider="fake",outcome="success"} 1`,
+		`stddata_records{provider="fake"} 2`,
+		`stddata_ready{provider="down"} 0`,
+		`stddata_ready{provider="fake"} 1`,
+		`stddata_dataset_age_seconds{provider="fake"} `,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("Expected %s in\n%s\n", line, body)
+		}
+	}
+	if strings.Contains(body, `stddata_dataset_age_seconds{provider="down"}`) {
+		t.Fatalf("Expected no age for a provider that has not loaded\n%s\n", body)
+	}
+}
+func TestMetricsEscape(t *testing.T) {
+	if got := escape("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("Unexpected escape %s\n", got)
+	}
+}
+func TestResultSize(t *testing.T) {
+	// a resolve search of a routing number that was never replaced
+	type replacement struct{ Routing, NewRoutingNumber string }
+	type resolveResult struct {
+		Routing, Current string
+		Chain            []replacement
+	}
+	cases := []struct {
+		result interface{}
+		size   int
+	}{
+		{[]string{"a", "b"}, 2},
+		{result, 2},
+		{&result, 2},
+		{resolveResult{Routing: "1", Current: "1"}, 1},
+		{resolveResult{Chain: []replacement{{"1", "2"}, {"2", "3"}}}, 1},
+		{struct{ Routing string }{"1"}, 1},
+	}
+	for _, c := range cases {
+		if n := resultSize(c.result); n != c.size {
+			t.Errorf("%+v: expected %d, got %d\n", c.result, c.size, n)
+		}
+	}
+}
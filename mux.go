@@ -0,0 +1,161 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mux serves the searches of several Services under one http.Handler.
+// Each Service is mounted at /{name}, and / serves the Catalog of the
+// Services. /healthz and /readyz answer liveness and readiness probes,
+// and /metrics serves the Metrics of the Services.
+// A Mux can be mounted within another server's routes, as in
+//
+//	http.Handle("/stddata/", http.StripPrefix("/stddata", mux))
+type Mux struct {
+	mu       sync.RWMutex
+	services map[string]*Service
+	metrics  *Metrics
+}
+
+// NewMux returns an empty Mux.
+func NewMux() *Mux {
+	return &Mux{services: make(map[string]*Service), metrics: NewMetrics()}
+}
+
+// Catalog is the json body that a Mux serves at /.
+type Catalog struct {
+	Providers []CatalogEntry `json:"providers"`
+}
+
+// CatalogEntry describes one Service of a Mux.
+type CatalogEntry struct {
+	Name    string      `json:"name"`
+	Path    string      `json:"path"`
+	State   State       `json:"state"`
+	Count   int         `json:"count"`
+	Indexes []IndexInfo `json:"indexes"`
+}
+
+// Register loads p and mounts it at /{name}, and returns the Service
+// that serves it. A Provider that fails to load is mounted anyway, so
+// that its searches get 503 Service Unavailable until a Reload
+// succeeds; the load error is returned.
+func (m *Mux) Register(name string, p Provider) (s *Service, err error) {
+	s = &Service{Metrics: m.metrics}
+	err = s.LoadProvider(p, name)
+	m.Handle(name, s)
+	return s, err
+}
+
+// reservedNames are the names that a Mux serves itself.
+var reservedNames = map[string]bool{
+	"healthz": true,
+	"readyz":  true,
+	"metrics": true,
+}
+
+// Handle mounts s at /{name}. A Service that has no Metrics records
+// into the Mux's. Handle panics if name is empty, contains a slash, is
+// reserved, or is already mounted.
+func (m *Mux) Handle(name string, s *Service) {
+	if name == "" || strings.Contains(name, "/") || reservedNames[name] {
+		panic("stddata: invalid provider name " + name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.services[name]; found {
+		panic("stddata: provider " + name + " is already mounted")
+	}
+	if s.Metrics == nil {
+		s.Metrics = m.metrics
+	}
+	m.services[name] = s
+}
+
+// Service returns the Service mounted at /{name}.
+func (m *Mux) Service(name string) (s *Service, found bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, found = m.services[name]
+	return s, found
+}
+
+// names returns the names of the mounted Services, sorted.
+func (m *Mux) names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.services))
+	for name := range m.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Catalog describes the mounted Services, in order of their names.
+func (m *Mux) Catalog() Catalog {
+	c := Catalog{Providers: make([]CatalogEntry, 0)}
+	for _, name := range m.names() {
+		s, _ := m.Service(name)
+		state, _ := s.Provider.State()
+		c.Providers = append(c.Providers, CatalogEntry{
+			Name:    name,
+			Path:    "/" + name,
+			State:   state,
+			Count:   s.count(),
+			Indexes: s.Provider.Indexes(),
+		})
+	}
+	return c
+}
+
+// ServeHTTP serves the Catalog at /, passes searches of /{name} to the
+// Service mounted there, and serves the Service's IndexList at
+// /{name}/_indexes, its Meta at /{name}/_meta, and its probes at
+// /{name}/_healthz and /{name}/_readyz. Other paths get 404 Not Found.
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch path {
+	case "":
+		writeJSON(w, http.StatusOK, m.Catalog())
+		return
+	case "healthz":
+		m.serveHealth(w, r)
+		return
+	case "readyz":
+		m.serveReady(w, r)
+		return
+	case "metrics":
+		services := make([]*Service, 0)
+		for _, name := range m.names() {
+			s, _ := m.Service(name)
+			services = append(services, s)
+		}
+		m.metrics.serve(w, services)
+		return
+	}
+	name, rest, _ := strings.Cut(path, "/")
+	s, found := m.Service(name)
+	switch {
+	case found && rest == "":
+		s.ServeHTTP(w, r)
+	case found && rest == "_indexes":
+		s.IndexesHandler().ServeHTTP(w, r)
+	case found && rest == "_meta":
+		s.MetaHandler().ServeHTTP(w, r)
+	case found && rest == "_healthz":
+		s.HealthHandler().ServeHTTP(w, r)
+	case found && rest == "_readyz":
+		s.ReadyHandler().ServeHTTP(w, r)
+	default:
+		msg := "No provider at " + r.URL.Path
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Code: http.StatusNotFound, Message: msg})
+	}
+}
@@ -0,0 +1,86 @@
+package stddata
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/musicbeat/stddata/index"
+)
+
+func newMux() *Mux {
+	m := NewMux()
+	m.Register("fake", &fakeProvider{data: []string{"a", "b"}})
+	m.Register("down", &fakeProvider{err: errors.New("upstream is down")})
+	return m
+}
+
+func TestMuxCatalog(t *testing.T) {
+	w, _ := get(t, newMux(), "/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", w.Code)
+	}
+	var c Catalog
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if len(c.Providers) != 2 {
+		t.Fatalf("Expected 2 providers, got %+v\n", c)
+	}
+	down, fake := c.Providers[0], c.Providers[1]
+	if fake.Name != "fake" || fake.Path != "/fake" || fake.Count != 2 || fake.Indexes[0].Cardinality != 2 {
+		t.Fatalf("Unexpected entry %+v\n", fake)
+	}
+	if down.Name != "down" || down.Count != 0 || down.Indexes[0].Cardinality != 0 {
+		t.Fatalf("Unexpected entry %+v\n", down)
+	}
+	if fake.State != Ready || down.State != Failed {
+		t.Fatalf("Unexpected states %v, %v\n", fake.State, down.State)
+	}
+}
+func TestMuxSearch(t *testing.T) {
+	m := newMux()
+	w, _ := get(t, m, "/fake?name=a")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"b"`) {
+		t.Fatalf("Unexpected response %d %s\n", w.Code, w.Body.String())
+	}
+	w, e := get(t, m, "/down?name=a")
+	if w.Code != http.StatusServiceUnavailable || e.Provider != "down" {
+		t.Fatalf("Unexpected error %d %+v\n", w.Code, e)
+	}
+	w, e = get(t, m, "/missing?name=a")
+	if w.Code != http.StatusNotFound || e.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected error %d %+v\n", w.Code, e)
+	}
+}
+func TestMuxHandleTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic")
+		}
+	}()
+	newMux().Handle("fake", new(Service))
+}
+func TestMuxIndexes(t *testing.T) {
+	w, _ := get(t, newMux(), "/fake/_indexes")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d\n", w.Code)
+	}
+	var l IndexList
+	if err := json.Unmarshal(w.Body.Bytes(), &l); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	want := IndexInfo{Name: "name", Key: "Name", Match: index.Prefix, Cardinality: 2}
+	if l.Provider != "fake" || len(l.Indexes) != 1 || l.Indexes[0] != want {
+		t.Fatalf("Unexpected index list %+v\n", l)
+	}
+	if !strings.Contains(w.Body.String(), `"match": "prefix"`) {
+		t.Fatalf("Expected the match by name in %s\n", w.Body.String())
+	}
+	w, _ = get(t, newMux(), "/fake/_other")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404, got %d\n", w.Code)
+	}
+}
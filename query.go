@@ -0,0 +1,192 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed search request.
+type Query struct {
+	Index  string   // the index to search
+	Q      string   // the value to match in the index, or "_dump"
+	Limit  int      // the most results to return; 0 means no limit
+	Offset int      // the number of results to skip
+	Format string   // the format of the response; empty to negotiate it
+	Fields []string // the fields of each result to return; nil means all
+}
+
+// parameters, other than index and q, that a request may carry.
+var reserved = map[string]bool{
+	"limit":  true,
+	"offset": true,
+	"format": true,
+	"fields": true,
+}
+
+// ParseQuery parses the query string of a search request. The index
+// and the value to match are given by the index and q parameters, as
+// in "index=name&q=BANK%20OF". The older form, "name=BANK%20OF", is
+// also accepted. Values are percent-decoded. The format parameter
+// selects json, csv, xml or ndjson; without it, Format is empty and the
+// Service negotiates the format with the request's Accept header. A request that cannot be
+// parsed gets a ServiceError with http.StatusBadRequest that names the
+// bad parameter.
+func ParseQuery(rawQuery string) (q Query, err error) {
+	v, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return q, badRequest("Malformed query string: " + err.Error())
+	}
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(v[name]) > 1 {
+			return q, badRequest("Parameter " + name + " is repeated")
+		}
+	}
+
+	_, hasIndex := v["index"]
+	_, hasQ := v["q"]
+	switch {
+	case hasIndex && hasQ:
+		q.Index, q.Q = v.Get("index"), v.Get("q")
+		for _, name := range names {
+			if name != "index" && name != "q" && !reserved[name] {
+				return q, badRequest("Unknown parameter " + name)
+			}
+		}
+	case hasIndex:
+		return q, badRequest("Missing parameter q")
+	case hasQ:
+		return q, badRequest("Missing parameter index")
+	default:
+		// the older form: the one parameter that is not reserved
+		// names the index.
+		for _, name := range names {
+			if reserved[name] {
+				continue
+			}
+			if q.Index != "" {
+				return q, badRequest("Unknown parameter " + name)
+			}
+			q.Index, q.Q = name, v.Get(name)
+		}
+		if q.Index == "" {
+			return q, badRequest("Missing parameter index")
+		}
+	}
+	if q.Index == "" {
+		return q, badRequest("Parameter index is empty")
+	}
+	if q.Q == "" {
+		return q, badRequest("Parameter q is empty")
+	}
+
+	if q.Limit, err = nonNegative(v, "limit"); err != nil {
+		return q, err
+	}
+	if q.Offset, err = nonNegative(v, "offset"); err != nil {
+		return q, err
+	}
+	if f, found := v["format"]; found {
+		q.Format = strings.ToLower(f[0])
+		if _, supported := formats[q.Format]; !supported {
+			return q, badRequest("Parameter format has unsupported value " + f[0])
+		}
+	}
+	if f, found := v["fields"]; found {
+		for _, name := range strings.Split(f[0], ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return q, badRequest("Parameter fields has an empty field name")
+			}
+			q.Fields = append(q.Fields, name)
+		}
+	}
+	return q, nil
+}
+
+// nonNegative returns the value of the named parameter, which must be
+// a non-negative integer, or 0 if the parameter is absent.
+func nonNegative(v url.Values, name string) (int, error) {
+	s, found := v[name]
+	if !found {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s[0])
+	if err != nil || n < 0 {
+		return 0, badRequest("Parameter " + name + " must be a non-negative integer")
+	}
+	return n, nil
+}
+
+func badRequest(msg string) error {
+	return &ServiceError{Msg: msg, Code: http.StatusBadRequest}
+}
+
+// project returns the json form of v, keeping only the named fields of
+// each result. A result is a json object that is an element of an
+// array; objects that are not in arrays (the wrapper around the
+// results) are kept whole. Field names are matched without regard
+// to case.
+func project(v interface{}, fields []string) (interface{}, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(j, &tree); err != nil {
+		return nil, err
+	}
+	return projectTree(tree, keepSet(fields), false), nil
+}
+
+// projectRecord is like project, for a v that is itself a result.
+func projectRecord(v interface{}, fields []string) (interface{}, error) {
+	res, err := project([]interface{}{v}, fields)
+	if err != nil {
+		return nil, err
+	}
+	return res.([]interface{})[0], nil
+}
+
+// keepSet returns the set of the folded names of fields.
+func keepSet(fields []string) map[string]bool {
+	keep := make(map[string]bool)
+	for _, f := range fields {
+		keep[strings.ToLower(f)] = true
+	}
+	return keep
+}
+
+func projectTree(tree interface{}, keep map[string]bool, inArray bool) interface{} {
+	switch t := tree.(type) {
+	case []interface{}:
+		for i := range t {
+			t[i] = projectTree(t[i], keep, true)
+		}
+	case map[string]interface{}:
+		if inArray {
+			for k := range t {
+				if !keep[strings.ToLower(k)] {
+					delete(t, k)
+				}
+			}
+			return t
+		}
+		for k := range t {
+			t[k] = projectTree(t[k], keep, false)
+		}
+	}
+	return tree
+}
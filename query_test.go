@@ -0,0 +1,81 @@
+package stddata
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want Query
+	}{
+		{"name=Abc", Query{Index: "name", Q: "Abc"}},
+		{"name=_dump", Query{Index: "name", Q: "_dump"}},
+		{"name=A=B", Query{Index: "name", Q: "A=B"}},
+		{"name=BANK%20OF", Query{Index: "name", Q: "BANK OF"}},
+		{"index=name&q=BANK+OF&limit=10&offset=20", Query{Index: "name", Q: "BANK OF", Limit: 10, Offset: 20}},
+		{"q=ab&index=name&format=JSON&fields=Routing,%20City", Query{Index: "name", Q: "ab", Format: "json", Fields: []string{"Routing", "City"}}},
+		{"number=123&limit=5", Query{Index: "number", Q: "123", Limit: 5}},
+		{"name=a&format=CSV&fields=Routing", Query{Index: "name", Q: "a", Format: "csv", Fields: []string{"Routing"}}},
+	}
+	for _, c := range cases {
+		got, err := ParseQuery(c.raw)
+		if err != nil {
+			t.Errorf("%s: Err %v\n", c.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Expected %+v, got %+v\n", c.raw, c.want, got)
+		}
+	}
+}
+func TestParseQueryErrors(t *testing.T) {
+	cases := []struct {
+		raw   string
+		param string // named in the error message
+	}{
+		{"", "index"},
+		{"name=", "q"},
+		{"name", "q"},
+		{"=abc", "index"},
+		{"index=name", "q"},
+		{"q=abc", "index"},
+		{"index=name&q=a&color=red", "color"},
+		{"name=a&city=b", "name"},
+		{"name=a&name=b", "name"},
+		{"name=a&limit=-1", "limit"},
+		{"name=a&offset=x", "offset"},
+		{"name=a&format=pdf", "format"},
+		{"name=a&fields=Routing,,City", "fields"},
+		{"name=%zz", "query string"},
+	}
+	for _, c := range cases {
+		_, err := ParseQuery(c.raw)
+		serr, ok := err.(*ServiceError)
+		if !ok || serr.Code != http.StatusBadRequest {
+			t.Errorf("%s: Expected 400 ServiceError, got %v\n", c.raw, err)
+			continue
+		}
+		if !strings.Contains(serr.Msg, c.param) {
+			t.Errorf("%s: Expected the error to name %s, got %s\n", c.raw, c.param, serr.Msg)
+		}
+	}
+}
+func TestProject(t *testing.T) {
+	type rec struct{ Routing, City, Name string }
+	v := struct{ Recs [][]rec }{[][]rec{{{"1", "A", "X"}}, {{"2", "B", "Y"}}}}
+	got, err := project(v, []string{"routing", "CITY"})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	want := map[string]interface{}{"Recs": []interface{}{
+		[]interface{}{map[string]interface{}{"Routing": "1", "City": "A"}},
+		[]interface{}{map[string]interface{}{"Routing": "2", "City": "B"}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v\n", want, got)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CachedSource reads data from Source, and keeps a snapshot of the
+// data of the last Load that succeeded in the directory Dir. When
+// Source cannot be opened, and the Provider has no data to go on
+// serving, the snapshot is read instead, and the LoadInfo reports the
+// data set as Stale.
+//
+// A snapshot is kept in two files: Name.data, which holds the data,
+// and Name.json, which records where and when it was retrieved. When
+// Name is empty, it is derived from Source.
+type CachedSource struct {
+	Source Source
+	Dir    string
+	Name   string
+
+	// mu guards the state of the Open in progress: the file that the
+	// data is copied to, or the time of the snapshot that is read.
+	mu         sync.Mutex
+	pending    *cacheWriter
+	fetchedAt  time.Time
+	stale      bool
+	snapshotAt time.Time
+}
+
+// snapshotMeta is the content of a snapshot's json file.
+type snapshotMeta struct {
+	Source    string    `json:"source"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// Open implements the Source interface.
+func (s *CachedSource) Open() (io.ReadCloser, error) {
+	return s.open(false)
+}
+
+// OpenIfModified implements the Conditional interface. When Source is
+// not Conditional, it is opened with Open. A Provider that makes a
+// conditional Load has data to go on serving, so the snapshot is not
+// read when Source fails.
+func (s *CachedSource) OpenIfModified() (io.ReadCloser, error) {
+	return s.open(true)
+}
+
+func (s *CachedSource) String() string {
+	return s.Source.String()
+}
+
+func (s *CachedSource) open(conditional bool) (io.ReadCloser, error) {
+	s.abort()
+	var body io.ReadCloser
+	var err error
+	if c, ok := s.Source.(Conditional); ok && conditional {
+		body, err = c.OpenIfModified()
+	} else {
+		body, err = s.Source.Open()
+	}
+	switch {
+	case err == nil:
+		return s.copy(body), nil
+	case err == ErrNotModified || conditional:
+		return nil, err
+	}
+	snapshot, serr := s.openSnapshot()
+	if serr != nil {
+		return nil, fmt.Errorf("%v; no snapshot to fall back on: %v", err, serr)
+	}
+	log.Printf("Source %s failed, reading the snapshot in %s. %s\n", s, s.Dir, err)
+	return snapshot, nil
+}
+
+// copy returns a reader of body that copies the data to a temporary
+// file, which becomes the snapshot if the Load succeeds. A failure to
+// write the file fails the snapshot, not the Load.
+func (s *CachedSource) copy(body io.ReadCloser) io.ReadCloser {
+	w := new(cacheWriter)
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		w.err = err
+	} else {
+		w.f, w.err = ioutil.TempFile(s.Dir, s.name()+".*.tmp")
+	}
+	s.mu.Lock()
+	s.pending, s.fetchedAt, s.stale = w, time.Now(), false
+	s.mu.Unlock()
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(body, w), body}
+}
+
+// openSnapshot opens the snapshot's data, and records its time.
+func (s *CachedSource) openSnapshot() (io.ReadCloser, error) {
+	f, err := os.Open(s.path(".data"))
+	if err != nil {
+		return nil, err
+	}
+	var meta snapshotMeta
+	if j, err := ioutil.ReadFile(s.path(".json")); err == nil {
+		json.Unmarshal(j, &meta)
+	}
+	if meta.FetchedAt.IsZero() {
+		if fi, err := f.Stat(); err == nil {
+			meta.FetchedAt = fi.ModTime()
+		}
+	}
+	s.mu.Lock()
+	s.stale, s.snapshotAt = true, meta.FetchedAt
+	s.mu.Unlock()
+	return f, nil
+}
+
+// commit makes the data that was copied by the last Open the snapshot.
+func (s *CachedSource) commit() {
+	if snap, ok := s.Source.(snapshotter); ok {
+		snap.commit()
+	}
+	s.mu.Lock()
+	w, fetchedAt := s.pending, s.fetchedAt
+	s.pending = nil
+	s.mu.Unlock()
+	if w == nil {
+		return
+	}
+	err := w.close()
+	if err == nil {
+		err = os.Rename(w.f.Name(), s.path(".data"))
+	}
+	if err == nil {
+		j, _ := json.MarshalIndent(snapshotMeta{Source: s.String(), FetchedAt: fetchedAt}, "", "  ")
+		err = ioutil.WriteFile(s.path(".json"), j, 0644)
+	}
+	if err != nil {
+		log.Printf("Failed to keep a snapshot of %s in %s. %s\n", s, s.Dir, err)
+		w.remove()
+	}
+}
+
+// abort discards the data that was copied by the last Open.
+func (s *CachedSource) abort() {
+	if snap, ok := s.Source.(snapshotter); ok {
+		snap.abort()
+	}
+	s.mu.Lock()
+	w := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if w != nil {
+		w.close()
+		w.remove()
+	}
+}
+
+// snapshot reports whether the last Open read the snapshot, and when
+// the snapshot was retrieved.
+func (s *CachedSource) snapshot() (stale bool, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stale, s.snapshotAt
+}
+
+func (s *CachedSource) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	sum := sha256.Sum256([]byte(s.Source.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *CachedSource) path(ext string) string {
+	return filepath.Join(s.Dir, s.name()+ext)
+}
+
+// cacheWriter writes to a temporary file, and remembers, rather than
+// returns, the first error.
+type cacheWriter struct {
+	f   *os.File
+	err error
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.f.Write(b)
+	}
+	return len(b), nil
+}
+
+func (w *cacheWriter) close() error {
+	if w.f == nil {
+		return w.err
+	}
+	if err := w.f.Close(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *cacheWriter) remove() {
+	if w.f != nil {
+		os.Remove(w.f.Name())
+	}
+}
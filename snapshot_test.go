@@ -0,0 +1,132 @@
+package stddata
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// load loads src into s as a provider would, finishing with fail.
+func load(s *Status, src Source, fail error) (string, error) {
+	s.Begin()
+	body, err := s.Open(src)
+	if err == ErrNotModified {
+		s.Finish(nil)
+		return "", err
+	}
+	if err != nil {
+		s.Finish(err)
+		return "", err
+	}
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+	s.Finish(fail)
+	return string(data), fail
+}
+
+func TestCachedSourceFallsBack(t *testing.T) {
+	dir := t.TempDir()
+	u := new(upstream)
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+
+	var s Status
+	src := &CachedSource{Source: &HTTPSource{URL: ts.URL, Retries: -1}, Dir: dir, Name: "fake"}
+	if _, err := load(&s, src, nil); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "fake.data")); err != nil || string(data) != "data" {
+		t.Fatalf("Expected the snapshot \"data\", got %q, %v\n", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "fake.json")); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if s.Info().Stale {
+		t.Fatal("Expected a fresh data set")
+	}
+
+	// a new provider, whose upstream fails, reads the snapshot
+	u.failures = []int{http.StatusInternalServerError}
+	var fresh Status
+	src = &CachedSource{Source: &HTTPSource{URL: ts.URL, Retries: -1}, Dir: dir, Name: "fake"}
+	data, err := load(&fresh, src, nil)
+	if err != nil || data != "data" {
+		t.Fatalf("Expected the snapshot, got %q, %v\n", data, err)
+	}
+	info := fresh.Info()
+	if info.State != Ready || !info.Stale || info.SnapshotAt.IsZero() {
+		t.Fatalf("Expected a stale data set, got %+v\n", info)
+	}
+	if info.SnapshotAt.After(info.LoadedAt) {
+		t.Fatalf("Snapshot at %v is after the load at %v\n", info.SnapshotAt, info.LoadedAt)
+	}
+
+	// a reload from upstream is fresh again
+	if _, err := load(&fresh, src, nil); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if fresh.Info().Stale {
+		t.Fatal("Expected a fresh data set after a reload")
+	}
+}
+
+func TestCachedSourceAbort(t *testing.T) {
+	dir := t.TempDir()
+	var s Status
+	src := &CachedSource{Source: &ReaderSource{Name: "good", Reader: strings.NewReader("good")}, Dir: dir, Name: "fake"}
+	if _, err := load(&s, src, nil); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	src.Source = &ReaderSource{Name: "bad", Reader: strings.NewReader("bad")}
+	if _, err := load(&s, src, errors.New("unparsable")); err == nil {
+		t.Fatal("Expected the load to fail")
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("Expected only the snapshot, got %v\n", files)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "fake.data")); string(data) != "good" {
+		t.Fatalf("Expected the snapshot of the good load, got %q\n", data)
+	}
+}
+
+func TestCachedSourceNoSnapshot(t *testing.T) {
+	var s Status
+	src := &CachedSource{Source: &FileSource{Path: "testdata/missing"}, Dir: t.TempDir()}
+	if _, err := load(&s, src, nil); err == nil {
+		t.Fatal("Expected the load to fail without a snapshot")
+	}
+	if state, _ := s.State(); state != Failed {
+		t.Fatalf("Expected Failed, got %v\n", state)
+	}
+}
+
+func TestCachedSourceConditional(t *testing.T) {
+	dir := t.TempDir()
+	u := new(upstream)
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+
+	var s Status
+	src := &CachedSource{Source: &HTTPSource{URL: ts.URL, Retries: -1}, Dir: dir}
+	if _, err := load(&s, src, nil); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if _, err := load(&s, src, nil); err != ErrNotModified {
+		t.Fatalf("Expected ErrNotModified, got %v\n", err)
+	}
+	// a provider that has data goes on serving it, rather than the
+	// snapshot, when upstream fails
+	u.failures = []int{http.StatusInternalServerError}
+	if _, err := load(&s, src, nil); err == nil {
+		t.Fatal("Expected the reload to fail")
+	}
+	if info := s.Info(); info.State != Ready || info.Stale {
+		t.Fatalf("Expected the fresh data set to be kept, got %+v\n", info)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Source is the interface for the origin of a Provider's data. A
+// Provider reads its standard data set through a Source, so the same
+// Provider can load from the live internet, a vendored snapshot on
+// disk, or data compiled into the program.
+type Source interface {
+	// Open returns a reader positioned at the start of the data.
+	// The caller must close it when done.
+	Open() (io.ReadCloser, error)
+	// String describes the source (a URL, a path, a name) for use
+	// in log and error messages.
+	String() string
+}
+
+// FileSource reads data from a file on the local file system.
+type FileSource struct {
+	Path string
+}
+
+// Open implements the Source interface.
+func (s *FileSource) Open() (io.ReadCloser, error) {
+	return os.Open(s.Path)
+}
+
+func (s *FileSource) String() string {
+	return s.Path
+}
+
+// ReaderSource reads data from an io.Reader. Because a reader can
+// only be consumed once, a ReaderSource can only be opened once.
+type ReaderSource struct {
+	Name   string
+	Reader io.Reader
+	opened bool
+}
+
+// Open implements the Source interface.
+func (s *ReaderSource) Open() (io.ReadCloser, error) {
+	if s.opened {
+		return nil, errors.New("Source " + s.String() + " has already been read")
+	}
+	s.opened = true
+	return ioutil.NopCloser(s.Reader), nil
+}
+
+func (s *ReaderSource) String() string {
+	if s.Name == "" {
+		return "reader"
+	}
+	return s.Name
+}
+
+// EmbeddedSource reads data held in memory, typically a snapshot
+// compiled into the program. It can be opened any number of times.
+type EmbeddedSource struct {
+	Name string
+	Data []byte
+}
+
+// Open implements the Source interface.
+func (s *EmbeddedSource) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(s.Data)), nil
+}
+
+func (s *EmbeddedSource) String() string {
+	if s.Name == "" {
+		return "embedded"
+	}
+	return s.Name
+}
+
+// Defaults of an HTTPSource:
+const (
+	DefaultTimeout = 2 * time.Minute
+	DefaultRetries = 3
+	DefaultBackoff = time.Second
+)
+
+// ErrNotModified is returned by a Conditional Source's OpenIfModified
+// when the data has not changed since it was last opened.
+var ErrNotModified = errors.New("Source has not been modified")
+
+// Conditional is implemented by Sources that can tell when their data
+// has not changed, so that it need not be read again.
+type Conditional interface {
+	// OpenIfModified is like Open, but returns ErrNotModified if the
+	// data has not changed since it was last opened.
+	OpenIfModified() (io.ReadCloser, error)
+}
+
+// HTTPSource retrieves data with an http GET of URL. Failed requests
+// are retried with an exponential backoff. HTTPSource is Conditional:
+// it remembers the ETag and Last-Modified of the data of the last Load
+// that succeeded, and OpenIfModified sends them back in If-None-Match
+// and If-Modified-Since headers. The validators of data that a Load
+// fails to parse are not kept, so that the data is retrieved again.
+type HTTPSource struct {
+	URL string
+	// Client makes the requests. When it is nil, a client with Timeout
+	// and Proxy is used.
+	Client *http.Client
+	// Timeout limits each request. Zero means DefaultTimeout.
+	Timeout time.Duration
+	// Proxy chooses the proxy for a request, as http.Transport's Proxy
+	// does. When it is nil, the proxy is taken from the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy func(*http.Request) (*url.URL, error)
+	// Retries is the number of times a request that fails, or gets a
+	// 5xx or 429 response, is retried. Zero means DefaultRetries, and
+	// a negative number means none.
+	Retries int
+	// Backoff is the wait before the first retry, which doubles for
+	// each retry after it. Zero means DefaultBackoff.
+	Backoff time.Duration
+
+	// mu guards client, the validators of the data last loaded, and
+	// the validators of the data retrieved by the Load in progress,
+	// which are kept when it succeeds.
+	mu                 sync.Mutex
+	client             *http.Client
+	etag               string
+	lastModified       string
+	staged             bool
+	stagedETag         string
+	stagedLastModified string
+}
+
+// Open implements the Source interface. Any response other than
+// 200 OK is an error.
+func (s *HTTPSource) Open() (io.ReadCloser, error) {
+	return s.fetch(false)
+}
+
+// OpenIfModified implements the Conditional interface.
+func (s *HTTPSource) OpenIfModified() (io.ReadCloser, error) {
+	return s.fetch(true)
+}
+
+func (s *HTTPSource) String() string {
+	return s.URL
+}
+
+// fetch retrieves the data, retrying failed requests.
+func (s *HTTPSource) fetch(conditional bool) (io.ReadCloser, error) {
+	retries := s.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		body, retry, err := s.get(conditional)
+		if err == nil || !retry || attempt >= retries {
+			return body, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// get makes one request, and reports whether a failed request is worth
+// retrying.
+func (s *HTTPSource) get(conditional bool) (body io.ReadCloser, retry bool, err error) {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	client := s.httpClient()
+	s.mu.Lock()
+	if conditional {
+		if s.etag != "" {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if s.lastModified != "" {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+	s.mu.Unlock()
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	switch {
+	case res.StatusCode == http.StatusOK:
+		s.mu.Lock()
+		s.staged, s.stagedETag, s.stagedLastModified = true, res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+		s.mu.Unlock()
+		return res.Body, false, nil
+	case res.StatusCode == http.StatusNotModified && conditional:
+		res.Body.Close()
+		return nil, false, ErrNotModified
+	}
+	res.Body.Close()
+	retry = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return nil, retry, fmt.Errorf("GET %s: %s", s.URL, res.Status)
+}
+
+// commit keeps the validators of the data retrieved by the Load that
+// succeeded.
+func (s *HTTPSource) commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.staged {
+		s.etag, s.lastModified = s.stagedETag, s.stagedLastModified
+	}
+	s.staged, s.stagedETag, s.stagedLastModified = false, "", ""
+}
+
+// abort discards the validators of the data retrieved by the Load that
+// failed, keeping those of the data that is served.
+func (s *HTTPSource) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staged, s.stagedETag, s.stagedLastModified = false, "", ""
+}
+
+// snapshot implements snapshotter; an HTTPSource reads no snapshot.
+func (s *HTTPSource) snapshot() (stale bool, at time.Time) {
+	return false, time.Time{}
+}
+
+// httpClient returns Client, or the client made from Timeout and Proxy.
+func (s *HTTPSource) httpClient() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		timeout := s.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		proxy := s.Proxy
+		if proxy == nil {
+			proxy = http.ProxyFromEnvironment
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		s.client = &http.Client{Timeout: timeout, Transport: transport}
+	}
+	return s.client
+}
@@ -0,0 +1,173 @@
+package stddata
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// upstream serves "data" with an ETag, after failing with the codes in
+// failures, and counts its requests.
+type upstream struct {
+	failures []int
+	requests int
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.requests++
+	if len(u.failures) > 0 {
+		code := u.failures[0]
+		u.failures = u.failures[1:]
+		w.WriteHeader(code)
+		return
+	}
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	w.Write([]byte("data"))
+}
+
+func TestHTTPSourceRetries(t *testing.T) {
+	u := &upstream{failures: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+	src := &HTTPSource{URL: ts.URL, Backoff: time.Millisecond}
+	body, err := src.Open()
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	defer body.Close()
+	if data, _ := ioutil.ReadAll(body); string(data) != "data" || u.requests != 3 {
+		t.Fatalf("Unexpected %s after %d requests\n", data, u.requests)
+	}
+}
+func TestHTTPSourceGivesUp(t *testing.T) {
+	u := &upstream{failures: []int{500, 500, 500}}
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+	src := &HTTPSource{URL: ts.URL, Retries: 1, Backoff: time.Millisecond}
+	if _, err := src.Open(); err == nil || u.requests != 2 {
+		t.Fatalf("Expected failure after 2 requests, got %v after %d\n", err, u.requests)
+	}
+
+	// a client error is not retried
+	u = &upstream{failures: []int{http.StatusNotFound}}
+	ts404 := httptest.NewServer(u)
+	defer ts404.Close()
+	src = &HTTPSource{URL: ts404.URL, Backoff: time.Millisecond}
+	if _, err := src.Open(); err == nil || u.requests != 1 {
+		t.Fatalf("Expected failure after 1 request, got %v after %d\n", err, u.requests)
+	}
+}
+func TestHTTPSourceConditional(t *testing.T) {
+	u := new(upstream)
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+	src := &HTTPSource{URL: ts.URL}
+	body, err := src.Open()
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	body.Close()
+	// the validators are kept only once the Load succeeds
+	if body, err = src.OpenIfModified(); err != nil {
+		t.Fatalf("Expected the data before a commit, got %v\n", err)
+	}
+	body.Close()
+	src.commit()
+	if _, err := src.OpenIfModified(); err != ErrNotModified {
+		t.Fatalf("Expected ErrNotModified, got %v\n", err)
+	}
+	// Open always retrieves the data
+	if body, err = src.Open(); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	body.Close()
+}
+func TestStatusFailedLoadKeepsValidators(t *testing.T) {
+	u := new(upstream)
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+	src := &HTTPSource{URL: ts.URL}
+
+	var s Status
+	if _, err := load(&s, src, nil); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	// as if the data served were an older version: a reload that
+	// retrieves the new data, and fails to parse it, must not take the
+	// new data's validators
+	src.etag = `"v0"`
+	if _, err := load(&s, src, errors.New("truncated")); err == nil {
+		t.Fatal("Expected the reload to fail")
+	}
+	if data, err := load(&s, src, nil); err != nil || data != "data" {
+		t.Fatalf("Expected the data to be retrieved again, got %q, %v\n", data, err)
+	}
+	if _, err := load(&s, src, nil); err != ErrNotModified {
+		t.Fatalf("Expected ErrNotModified, got %v\n", err)
+	}
+}
+func TestHTTPSourceClientAndProxy(t *testing.T) {
+	u := new(upstream)
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+
+	proxied := false
+	src := &HTTPSource{URL: ts.URL, Proxy: func(r *http.Request) (*url.URL, error) {
+		proxied = true
+		return nil, nil
+	}}
+	body, err := src.Open()
+	if err != nil || !proxied {
+		t.Fatalf("Expected the Proxy to be consulted, got %v\n", err)
+	}
+	body.Close()
+
+	client := &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		return nil, http.ErrHandlerTimeout
+	})}
+	src = &HTTPSource{URL: ts.URL, Client: client, Retries: -1}
+	if _, err := src.Open(); err == nil || u.requests != 1 {
+		t.Fatalf("Expected the Client to be used, got %v\n", err)
+	}
+}
+
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+func TestStatusNotModified(t *testing.T) {
+	u := new(upstream)
+	ts := httptest.NewServer(u)
+	defer ts.Close()
+	src := &HTTPSource{URL: ts.URL}
+
+	var s Status
+	s.Begin()
+	body, err := s.Open(src)
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	ioutil.ReadAll(body)
+	body.Close()
+	s.Finish(nil)
+	loaded := s.Info()
+
+	s.Begin()
+	if _, err := s.Open(src); err != ErrNotModified {
+		t.Fatalf("Expected ErrNotModified, got %v\n", err)
+	}
+	s.Finish(nil)
+	info := s.Info()
+	if info.Checksum != loaded.Checksum || info.LoadedAt != loaded.LoadedAt || !info.CheckedAt.After(loaded.CheckedAt) {
+		t.Fatalf("Unexpected %+v after %+v\n", info, loaded)
+	}
+}
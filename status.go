@@ -0,0 +1,276 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stddata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State is a stage in the lifecycle of a Provider.
+type State int
+
+// Values of State:
+const (
+	NotLoaded State = iota // Load has not been called
+	Loading                // the first Load is in progress
+	Ready                  // a Load has succeeded; searches are served
+	Failed                 // every Load so far has failed
+)
+
+func (s State) String() string {
+	switch s {
+	case NotLoaded:
+		return "not loaded"
+	case Loading:
+		return "loading"
+	case Ready:
+		return "ready"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *State) UnmarshalText(text []byte) error {
+	for _, state := range []State{NotLoaded, Loading, Ready, Failed} {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return errors.New("unknown state " + string(text))
+}
+
+// Status tracks the lifecycle of a Provider. Its zero value is
+// NotLoaded. A Provider calls Begin when a Load starts, and Finish
+// with the Load's error when it ends. In between, it opens its Source
+// with Open, or names the data sets it is derived from with Derive, so
+// that the LoadInfo can describe where the data came from.
+//
+// A Provider that has loaded once stays Ready through later loads,
+// since it goes on serving its previous data; a failed reload is
+// reported by the error that State returns.
+type Status struct {
+	mu      sync.Mutex
+	state   State
+	lastErr error
+	// info describes the data set of the most recent successful Load.
+	info LoadInfo
+	// started, source, sum, checksum and notModified belong to the
+	// Load in progress.
+	started     time.Time
+	source      string
+	sum         hash.Hash
+	checksum    string
+	notModified bool
+	snap        snapshotter
+}
+
+// snapshotter is implemented by Sources that hold state about the data
+// that was opened until the Load ends: the validators of HTTPSource,
+// and the snapshot of CachedSource.
+type snapshotter interface {
+	// commit keeps the data that was opened, after a successful Load.
+	commit()
+	// abort discards the data that was opened, after a failed Load.
+	abort()
+	// snapshot reports whether the data that was opened is a snapshot,
+	// and when the snapshot was retrieved.
+	snapshot() (stale bool, at time.Time)
+}
+
+// LoadInfo describes the data set that a Provider serves.
+type LoadInfo struct {
+	State     State
+	LastError error         // the error of the most recent Load, if it failed
+	Source    string        // where the data set was read from
+	Checksum  string        // the SHA-256 of the data read from Source, in hex
+	LoadedAt  time.Time     // when the data set was loaded; zero until Ready
+	Duration  time.Duration // how long the Load of the data set took
+	CheckedAt time.Time     // when the Source was last read, or found to be unchanged
+	// Stale is true when the data set was read from a CachedSource's
+	// snapshot, because its Source could not be read. SnapshotAt is
+	// when the snapshot was retrieved.
+	Stale      bool
+	SnapshotAt time.Time
+}
+
+// Begin records the start of a Load.
+func (s *Status) Begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != Ready {
+		s.state = Loading
+	}
+	s.started = time.Now()
+	s.source, s.sum, s.checksum, s.notModified, s.snap = "", nil, "", false, nil
+}
+
+// Open opens src for the Load in progress. The data read from the
+// returned reader is summed for the LoadInfo's Checksum.
+//
+// When the Provider is serving data that it read from src, and src is
+// Conditional, Open returns ErrNotModified if the data has not changed.
+// The Provider should then go on serving its data, and Finish the Load
+// with no error; the LoadInfo is kept, with a new CheckedAt.
+func (s *Status) Open(src Source) (body io.ReadCloser, err error) {
+	s.mu.Lock()
+	current := s.state == Ready && s.info.Source == src.String()
+	s.mu.Unlock()
+	if c, ok := src.(Conditional); ok && current {
+		body, err = c.OpenIfModified()
+	} else {
+		body, err = src.Open()
+	}
+	if err == ErrNotModified {
+		s.mu.Lock()
+		s.notModified = true
+		s.mu.Unlock()
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	s.mu.Lock()
+	s.source, s.sum = src.String(), sum
+	s.snap, _ = src.(snapshotter)
+	s.mu.Unlock()
+	return &summingReader{ReadCloser: body, sum: sum}, nil
+}
+
+// Derive records that the Load in progress reads no Source of its
+// own, but joins the data sets described by from. The LoadInfo lists
+// their Sources, its Checksum is a sum of their Checksums, and it is
+// Stale if any of them is.
+func (s *Status) Derive(from ...LoadInfo) {
+	sources := make([]string, len(from))
+	sum := sha256.New()
+	for i, info := range from {
+		sources[i] = info.Source
+		io.WriteString(sum, info.Checksum)
+	}
+	var snap derivedSnapshot
+	for _, info := range from {
+		if info.Stale && (!snap.stale || info.SnapshotAt.Before(snap.at)) {
+			snap.stale, snap.at = true, info.SnapshotAt
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source, s.sum = strings.Join(sources, ", "), nil
+	s.checksum = hex.EncodeToString(sum.Sum(nil))
+	s.snap = snap
+}
+
+// derivedSnapshot is the snapshotter of a derived data set, which is
+// stale when any data set it joins is, as of the oldest snapshot.
+type derivedSnapshot struct {
+	stale bool
+	at    time.Time
+}
+
+func (d derivedSnapshot) commit() {}
+func (d derivedSnapshot) abort()  {}
+func (d derivedSnapshot) snapshot() (stale bool, at time.Time) {
+	return d.stale, d.at
+}
+
+// Finish records the end of a Load, which failed if err is not nil.
+func (s *Status) Finish(err error) {
+	s.mu.Lock()
+	snap := s.snap
+	s.mu.Unlock()
+	var stale bool
+	var snapshotAt time.Time
+	if snap != nil {
+		if err == nil {
+			snap.commit()
+			stale, snapshotAt = snap.snapshot()
+		} else {
+			snap.abort()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+	switch {
+	case err == nil && s.notModified:
+		s.info.CheckedAt = time.Now()
+	case err == nil:
+		s.state = Ready
+		now := time.Now()
+		s.info = LoadInfo{
+			Source:     s.source,
+			Checksum:   s.checksum,
+			LoadedAt:   now,
+			Duration:   now.Sub(s.started),
+			CheckedAt:  now,
+			Stale:      stale,
+			SnapshotAt: snapshotAt,
+		}
+		if s.sum != nil {
+			s.info.Checksum = hex.EncodeToString(s.sum.Sum(nil))
+		}
+	case s.state != Ready:
+		s.state = Failed
+	}
+	s.source, s.sum, s.checksum, s.notModified, s.snap = "", nil, "", false, nil
+}
+
+// State returns the current State, and the error of the most recent
+// Load if it failed.
+func (s *Status) State() (state State, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.lastErr
+}
+
+// Info returns the LoadInfo of the data set that is served.
+func (s *Status) Info() LoadInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := s.info
+	info.State, info.LastError = s.state, s.lastErr
+	return info
+}
+
+// Unavailable returns the ServiceError for a search of a Provider
+// that is not Ready.
+func (s *Status) Unavailable(entity string) *ServiceError {
+	state, err := s.State()
+	msg := entity + " is " + state.String()
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	return &ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
+}
+
+// summingReader adds the data that is read to sum.
+type summingReader struct {
+	io.ReadCloser
+	sum hash.Hash
+}
+
+func (r *summingReader) Read(b []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(b)
+	r.sum.Write(b[:n])
+	return n, err
+}
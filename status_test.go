@@ -0,0 +1,70 @@
+package stddata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestStatusLifecycle(t *testing.T) {
+	var s Status
+	if state, err := s.State(); state != NotLoaded || err != nil {
+		t.Fatalf("Unexpected %v, %v\n", state, err)
+	}
+	s.Begin()
+	if state, _ := s.State(); state != Loading {
+		t.Fatalf("Expected loading, got %v\n", state)
+	}
+	s.Finish(errors.New("upstream is down"))
+	if state, err := s.State(); state != Failed || err == nil {
+		t.Fatalf("Unexpected %v, %v\n", state, err)
+	}
+	s.Begin()
+	s.Finish(nil)
+	if state, err := s.State(); state != Ready || err != nil {
+		t.Fatalf("Unexpected %v, %v\n", state, err)
+	}
+	// a failed reload keeps serving the previous data
+	s.Begin()
+	if state, _ := s.State(); state != Ready {
+		t.Fatalf("Expected ready during a reload, got %v\n", state)
+	}
+	s.Finish(errors.New("upstream is down"))
+	if state, err := s.State(); state != Ready || err == nil {
+		t.Fatalf("Unexpected %v, %v\n", state, err)
+	}
+}
+func TestStatusUnavailable(t *testing.T) {
+	var s Status
+	s.Begin()
+	s.Finish(errors.New("upstream is down"))
+	serr := s.Unavailable("Bank directory")
+	if serr.Code != http.StatusServiceUnavailable || serr.Msg != "Bank directory is failed: upstream is down" {
+		t.Fatalf("Unexpected %+v\n", serr)
+	}
+}
+func TestStatusInfo(t *testing.T) {
+	var s Status
+	s.Begin()
+	body, err := s.Open(&EmbeddedSource{Name: "snapshot", Data: []byte("data")})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	ioutil.ReadAll(body)
+	body.Close()
+	s.Finish(nil)
+	info := s.Info()
+	sum := sha256.Sum256([]byte("data"))
+	if info.Source != "snapshot" || info.Checksum != hex.EncodeToString(sum[:]) || info.LoadedAt.IsZero() {
+		t.Fatalf("Unexpected %+v\n", info)
+	}
+	// a failed reload keeps describing the data set that is served
+	s.Begin()
+	s.Finish(errors.New("upstream is down"))
+	if failed := s.Info(); failed.Checksum != info.Checksum || failed.LastError == nil {
+		t.Fatalf("Unexpected %+v\n", failed)
+	}
+}
@@ -7,6 +7,7 @@ Package stddata provides a simple mechanism for making
 information about "standard" data sets available
 for lookups and queries. The standards are:
 	Federal Reserve E-Payments Routing Directory
+	Federal Reserve Fedwire Funds Service Participant Directory
 	ISO 639 Language Codes
 	ISO 4217 Currency Codes
 	ISO 3166-1 Country Codes (Officially Assigned)
@@ -18,6 +19,11 @@ as follows:
 	stddata - interfaces, types, and functions for managing the data providers
 	stddata/bank - Federal Reserve E-Payments Routing Directory
 		A handy, fixed format text file available at the Fed's website
+	stddata/fedwire - Federal Reserve Fedwire Funds Service Participant Directory
+		Another fixed format text file available at the Fed's website
+	stddata/xref - ACH and Fedwire participation of a routing number
+		A join of the bank and fedwire data sets
+	stddata/index - the sorted, searchable indexes that the providers build
 	stddata/country - ISO 3166-1 Country Codes (Officially Assigned)
 		ISO charges for access to this information through their website, but
 		Wikipedia has a table of these codes. A data set was extracted from
@@ -27,6 +33,25 @@ as follows:
 	stddata/language - ISO 639 Language Codes
 		A handy, pipe-delimited csv file.
 
+Sources
+
+Each provider reads its data through a Source. By default the
+providers retrieve their data from the publishers' websites, but
+a FileSource, ReaderSource, EmbeddedSource or HTTPSource can be
+assigned to a provider's Source field before Load, for example to
+load a vendored snapshot where the internet is not reachable.
+
+An HTTPSource retries failed requests with an exponential backoff,
+can be given its own http.Client, timeout or proxy, and makes
+conditional requests on reloads, so that a data set that has not
+changed upstream is not retrieved again.
+
+A provider with a CacheDir keeps a snapshot of the last data set it
+loaded there, through a CachedSource, and loads the snapshot when its
+Source fails. A data set read from a snapshot is reported as Stale in
+the LoadInfo, in _meta and in the stddata_stale metric, and its search
+responses carry a Warning header.
+
 */
 package stddata
 
@@ -37,22 +62,47 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strings"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // Provider is the interface for a Standard Data Provider. A type that 
 // implements Provider's methods can be managed as a Standard Data Provider.
+//
+// Service calls Search from many goroutines at once, and Reload calls
+// Load while those searches are in progress. Implementations must be
+// safe for that: Search must see either the data set from before a
+// Load or the one from after it, never a mix of the two.
 type Provider interface {
 	// Load loads the data, according to the needs of the particular
 	// implementation's standard data set. It returns the number of
 	// items it has loaded. If an error occurs, it returns that as well.
 	Load() (n int, err error)
-	// Search takes the name of the index to be searched, and the value
-	// to match in that index. It returns an interface and an error.
-	// The value that is returned as v is intended to be marshaled as
-	// json -- it is expected to be the collection of entities that
-	// match the search.
-	Search(index string, q string) (v interface{}, err error)
+	// Search takes the name of the index to be searched, the value
+	// to match in that index, and the page of the matches to return.
+	// It returns an interface and an error. The value that is returned
+	// as v is intended to be marshaled as json -- it is expected to be
+	// the page of the collection of entities that match the search,
+	// along with the total number of matches.
+	Search(index string, q string, page Page) (v interface{}, err error)
+	// State reports where the Provider is in its lifecycle, and the
+	// error of its most recent Load if that failed. Searches are
+	// served only when the State is Ready.
+	State() (state State, err error)
+	// LoadInfo describes the data set that the Provider serves: its
+	// Source, a checksum of the source data, and when and how quickly
+	// it was loaded.
+	LoadInfo() LoadInfo
+	// Indexes describes the indexes that Search accepts, sorted by
+	// name. The Cardinality of each is 0 until the Provider has loaded.
+	Indexes() []IndexInfo
+}
+
+// Page selects a window of a search's matches.
+type Page struct {
+	Offset int // the number of matches to skip
+	Limit  int // the most matches to return; 0 means no limit
 }
 
 // Service is used to handle http access to the stddata providers' data.
@@ -60,8 +110,27 @@ type Service struct {
 	Provider   Provider
 	Count      int
 	EntityName string
+	// RetryAfter is sent with 503 Service Unavailable responses. When
+	// it is zero, DefaultRetryAfter is sent.
+	RetryAfter time.Duration
+	// MaxAge is the max-age of the Cache-Control header of successful
+	// searches. When it is zero, DefaultMaxAge is sent; when it is
+	// negative, responses must be revalidated with each use.
+	MaxAge time.Duration
+	// Metrics, when it is not nil, records the Service's searches and
+	// loads.
+	Metrics *Metrics
+	// reloadMu allows one reload at a time.
+	reloadMu sync.Mutex
+	// countMu guards Count, which is read by a Mux's catalog while
+	// a reload may be setting it.
+	countMu sync.Mutex
 }
 
+// DefaultRetryAfter is the Retry-After of a Service that does not set
+// its own.
+const DefaultRetryAfter = 60 * time.Second
+
 // LoadProvider is used to prepare the data.
 //
 // Implementations retrieve their source data, and index it for
@@ -70,67 +139,275 @@ func (s *Service) LoadProvider(p Provider, e string) (err error) {
 	s.Provider = p
 	s.EntityName = e
 	n, err := s.Provider.Load()
+	if s.Metrics != nil {
+		s.Metrics.observeLoad(e, err)
+	}
 	if err != nil {
 		log.Printf("Provider for %s failed to load. %s\n", e, err)
 		return errors.New("Searches will get 503 Service Unavailable for this provider")
 	}
-	s.Count = n
+	s.setCount(n)
 	return nil
 }
 
+// Reload loads the Provider's data again. The Provider builds the
+// fresh data set while it goes on serving the old one, and swaps the
+// new one in only if the load succeeds, so after a failed Reload the
+// previous data is still served.
+func (s *Service) Reload() (n int, err error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	n, err = s.Provider.Load()
+	if s.Metrics != nil {
+		s.Metrics.observeLoad(s.EntityName, err)
+	}
+	if err != nil {
+		log.Printf("Provider for %s failed to reload, the previous data is still served. %s\n", s.EntityName, err)
+		return 0, err
+	}
+	s.setCount(n)
+	return n, nil
+}
+
+func (s *Service) setCount(n int) {
+	s.countMu.Lock()
+	defer s.countMu.Unlock()
+	s.Count = n
+}
+
+func (s *Service) count() int {
+	s.countMu.Lock()
+	defer s.countMu.Unlock()
+	return s.Count
+}
+
+// ReloadEvery reloads the Provider's data every interval, in the
+// background, until the returned stop function is called.
+func (s *Service) ReloadEvery(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.Reload()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// ReloadHandler returns an http.Handler for an administrative
+// endpoint that reloads the Provider's data when it receives a POST.
+func (s *Service) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			s.writeError(w, &ServiceError{Msg: "Reload requires POST", Code: http.StatusMethodNotAllowed}, Query{})
+			return
+		}
+		n, err := s.Reload()
+		if err != nil {
+			s.writeError(w, err, Query{})
+			return
+		}
+		io.WriteString(w, fmt.Sprintf("Reloaded %d %s\n", n, s.EntityName))
+	})
+}
+
 // ServeHTTP is the Service's implementation for searching.
 //
-// After some basic validation of the search request, the
-// Provider's Search() implementation is called. The response
-// from Search() is marshalled into json.
+// The request's query string is parsed by ParseQuery. After some basic
+// validation of the search request, the Provider's Search()
+// implementation is called for the page given by the offset and limit
+// parameters. The response from Search() is encoded in the format
+// given by the format parameter or, without it, the request's Accept
+// header: json, CSV, XML or newline-delimited json, keeping only the
+// requested fields when the fields parameter is given. The response is
+// streamed, one entity at a time, so that a _dump is not built in
+// memory before it is sent. Errors are reported with an ErrorResponse.
+//
+// A successful search carries an ETag made from the checksum of the
+// data set, a Last-Modified of the time it was loaded, and a
+// Cache-Control of the Service's MaxAge. A conditional request that
+// the response satisfies gets 304 Not Modified.
+// When the Service has Metrics, the search is recorded there.
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Metrics == nil {
+		s.serve(w, r)
+		return
+	}
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	q, res := s.serve(rec, r)
+	s.Metrics.observeSearch(s.EntityName, s.indexLabel(q.Index), rec.code, time.Since(start), res)
+}
+
+// serve answers a search, and returns the parsed query and, if the
+// search succeeded, its result.
+func (s *Service) serve(w http.ResponseWriter, r *http.Request) (q Query, res interface{}) {
 
 	// get the index and query values
-	var index, query string
-	var err error
-	if index, query, err = getQuery(r.URL.RawQuery); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	q, err := ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		s.writeError(w, err, q)
+		return q, nil
 	}
 
-	res, err := s.Provider.Search(index, query)
+	if state, _ := s.Provider.State(); state != Ready {
+		s.writeError(w, s.unavailable(), q)
+		return q, nil
+	}
+	format := q.Format
+	if format == "" {
+		if format, err = negotiate(r.Header.Get("Accept")); err != nil {
+			s.writeError(w, err, q)
+			return q, nil
+		}
+	}
+	if format == "xml" && q.Fields != nil {
+		s.writeError(w, badRequest("Parameter fields is not supported with format xml"), q)
+		return q, nil
+	}
+	// the LoadInfo is read before the search, so that a reload between
+	// the two cannot give older data a newer ETag
+	info := s.Provider.LoadInfo()
+	res, err = s.Provider.Search(q.Index, q.Q, Page{Offset: q.Offset, Limit: q.Limit})
+	if err == nil && q.Fields != nil {
+		err = checkFields(format, res, q.Fields)
+	}
 	if err != nil {
-		if serr, ok := err.(*ServiceError); ok {
-			w.WriteHeader(serr.Code)
-			return
+		s.writeError(w, err, q)
+		return q, nil
+	}
+	if q.Format == "" {
+		w.Header().Set("Vary", "Accept")
+	}
+	tag := s.setCacheHeaders(w, info, format)
+	if info.Stale {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	if notModified(r, tag, info.LoadedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return q, nil
+	}
+	w.Header().Set("Content-Type", formats[format])
+	fw := &flushWriter{w: w}
+	if err := encode(fw, format, res, q.Fields); err != nil {
+		if fw.n == 0 {
+			s.writeError(w, err, q)
+			return q, nil
 		}
-		log.Printf("Error %v\n", err)
-		return
+		// the status has been sent; the response is cut short
+		log.Printf("Error writing %s search of %s: %v\n", s.EntityName, q.Index, err)
 	}
-	// convert result to json
-	j, err := json.MarshalIndent(res, "", "  ")
+	return q, res
+}
+
+// flushEvery is the number of writes between flushes of a response.
+const flushEvery = 64
+
+// flushWriter writes a response, flushing it after the first write and
+// every flushEvery writes after that, so that the first bytes of a
+// large response are sent at once and the rest in chunks as it is
+// encoded.
+type flushWriter struct {
+	w      http.ResponseWriter
+	writes int
+	n      int // the number of bytes written
+}
+
+func (f *flushWriter) Write(b []byte) (n int, err error) {
+	n, err = f.w.Write(b)
+	f.n += n
+	if f.writes%flushEvery == 0 {
+		if flusher, ok := f.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	f.writes++
+	return n, err
+}
+
+// indexLabel returns the name of index for Metrics, which is "unknown"
+// unless the Provider has an index of that name, so that the number of
+// series does not grow with bad requests.
+func (s *Service) indexLabel(index string) string {
+	for _, info := range s.Provider.Indexes() {
+		if info.Name == index {
+			return index
+		}
+	}
+	return "unknown"
+}
+
+// unavailable returns the ServiceError for a Provider that is not Ready.
+func (s *Service) unavailable() *ServiceError {
+	state, err := s.Provider.State()
+	msg := "Provider for " + s.EntityName + " is " + state.String()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		msg += ": " + err.Error()
 	}
-	io.WriteString(w, fmt.Sprintf("%s\n", j))
+	return &ServiceError{Msg: msg, Code: http.StatusServiceUnavailable}
+}
+
+// ErrorResponse is the json body of a Service's error responses.
+type ErrorResponse struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Provider string `json:"provider,omitempty"`
+	Index    string `json:"index,omitempty"`
+	Query    string `json:"query,omitempty"`
 }
 
-// Get the "index=query" parts of the request, for example, "name=Abc".
-// Or for a dump of an index, "name=_dump". Or error.
-func getQuery(u string) (query string, index string, err error) {
-	v := strings.Split(u, "=")
-	if len(v) < 2 {
-		err := errors.New("Malformed request")
-		return index, query, err
+// retryAfterSeconds returns d in whole seconds for a Retry-After header,
+// rounded up, so that a wait of less than a second is not sent as 0.
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
 	}
-	index = v[0]
-	if len(index) < 1 {
-		err := errors.New("Malformed request")
-		return index, query, err
+	return seconds
+}
+
+// writeError responds to a failed request with an ErrorResponse. A
+// ServiceError supplies the status code; any other error is a 500.
+// A 503 carries a Retry-After header.
+func (s *Service) writeError(w http.ResponseWriter, err error, q Query) {
+	e := ErrorResponse{
+		Code:     http.StatusInternalServerError,
+		Message:  err.Error(),
+		Provider: s.EntityName,
+		Index:    q.Index,
+		Query:    q.Q,
 	}
-	query = v[1]
-	if len(query) < 1 {
-		err := errors.New("Malformed request")
-		return index, query, err
+	if serr, ok := err.(*ServiceError); ok {
+		e.Code = serr.Code
+	} else {
+		log.Printf("Error %v\n", err)
+	}
+	if e.Code == http.StatusServiceUnavailable {
+		retry := s.RetryAfter
+		if retry <= 0 {
+			retry = DefaultRetryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retry)))
 	}
-	return index, query, err
+	writeJSON(w, e.Code, e)
 }
+
+// writeJSON responds with code and the indented json of v.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	j, _ := json.MarshalIndent(v, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	io.WriteString(w, fmt.Sprintf("%s\n", j))
+}
+
 // ServiceError combines an http status code and an
 // application error message.
 type ServiceError struct {
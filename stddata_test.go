@@ -0,0 +1,212 @@
+package stddata
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musicbeat/stddata/index"
+)
+
+// fakeProvider loads the entities in data, or fails with err. Its
+// searches return result, or data when result is nil, or fail with
+// searchErr.
+type fakeProvider struct {
+	Status
+	data      []string
+	result    interface{}
+	err       error
+	searchErr error
+}
+
+func (p *fakeProvider) Load() (n int, err error) {
+	p.Begin()
+	defer func() { p.Finish(err) }()
+	if p.err != nil {
+		return 0, p.err
+	}
+	body, err := p.Open(&EmbeddedSource{Name: "fake", Data: []byte(strings.Join(p.data, "\n"))})
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	ioutil.ReadAll(body)
+	return len(p.data), nil
+}
+
+func (p *fakeProvider) LoadInfo() LoadInfo {
+	return p.Info()
+}
+
+func (p *fakeProvider) Indexes() []IndexInfo {
+	info := IndexInfo{Name: "name", Key: "Name", Match: index.Prefix}
+	if state, _ := p.State(); state == Ready {
+		info.Cardinality = len(p.data)
+	}
+	return []IndexInfo{info}
+}
+
+func (p *fakeProvider) Search(index string, q string, page Page) (v interface{}, err error) {
+	if p.searchErr != nil {
+		return nil, p.searchErr
+	}
+	if p.result != nil {
+		return p.result, nil
+	}
+	return p.data, nil
+}
+
+func TestReload(t *testing.T) {
+	p := &fakeProvider{data: []string{"a"}}
+	s := new(Service)
+	if err := s.LoadProvider(p, "fake"); err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	p.data = []string{"a", "b"}
+	n, err := s.Reload()
+	if err != nil || n != 2 || s.Count != 2 {
+		t.Fatalf("Unexpected reload %d, %v, count %d\n", n, err, s.Count)
+	}
+	p.err = errors.New("upstream is down")
+	if _, err := s.Reload(); err == nil {
+		t.Fatal("Expected the reload to fail")
+	}
+	if s.Count != 2 {
+		t.Fatalf("Expected a failed reload to keep the count, got %d\n", s.Count)
+	}
+}
+func TestReloadEvery(t *testing.T) {
+	p := &fakeProvider{data: []string{"a"}}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	p.data = []string{"a", "b", "c"}
+	stop := s.ReloadEvery(time.Millisecond)
+	defer stop()
+	for i := 0; i < 1000; i++ {
+		if s.count() == 3 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Expected a scheduled reload")
+}
+func TestReloadHandler(t *testing.T) {
+	p := &fakeProvider{data: []string{"a"}}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	h := s.ReloadHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405, got %d\n", w.Code)
+	}
+
+	p.data = []string{"a", "b"}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Reloaded 2 fake") {
+		t.Fatalf("Unexpected response %d %s\n", w.Code, w.Body.String())
+	}
+
+	p.err = &ServiceError{Msg: "upstream is down", Code: http.StatusServiceUnavailable}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d\n", w.Code)
+	}
+}
+
+// get serves a GET of url, and decodes the ErrorResponse.
+func get(t *testing.T, h http.Handler, url string) (*httptest.ResponseRecorder, ErrorResponse) {
+	return do(t, h, httptest.NewRequest("GET", url, nil))
+}
+
+// do serves r, and decodes the ErrorResponse.
+func do(t *testing.T, h http.Handler, r *http.Request) (*httptest.ResponseRecorder, ErrorResponse) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var e ErrorResponse
+	if w.Code >= http.StatusBadRequest {
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			t.Fatalf("Err %v in %s\n", err, w.Body.String())
+		}
+	}
+	return w, e
+}
+
+func TestErrorBadRequest(t *testing.T) {
+	s := &Service{Provider: &fakeProvider{}, EntityName: "fake"}
+	w, e := get(t, s, "/fake?index=name&q=a&limit=x")
+	if w.Code != http.StatusBadRequest || e.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d %+v\n", w.Code, e)
+	}
+	if !strings.Contains(e.Message, "limit") || e.Provider != "fake" || e.Index != "name" || e.Query != "a" {
+		t.Fatalf("Unexpected error %+v\n", e)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected Content-Type %s\n", w.Header().Get("Content-Type"))
+	}
+}
+func TestErrorServiceError(t *testing.T) {
+	p := &fakeProvider{searchErr: &ServiceError{Msg: "No index on city", Code: http.StatusBadRequest}}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	w, e := get(t, s, "/fake?city=a")
+	if w.Code != http.StatusBadRequest || e.Message != "No index on city" || e.Index != "city" {
+		t.Fatalf("Unexpected error %d %+v\n", w.Code, e)
+	}
+}
+func TestErrorUnavailable(t *testing.T) {
+	// a Provider that has not been loaded
+	s := &Service{Provider: &fakeProvider{}, EntityName: "fake", RetryAfter: 5 * time.Second}
+	w, e := get(t, s, "/fake?name=a")
+	if w.Code != http.StatusServiceUnavailable || e.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d %+v\n", w.Code, e)
+	}
+	if w.Header().Get("Retry-After") != "5" || !strings.Contains(e.Message, "not loaded") {
+		t.Fatalf("Unexpected 503 %s %+v\n", w.Header().Get("Retry-After"), e)
+	}
+}
+func TestErrorRetryAfterRoundsUp(t *testing.T) {
+	for retry, want := range map[time.Duration]string{
+		500 * time.Millisecond:  "1",
+		time.Nanosecond:         "1",
+		1500 * time.Millisecond: "2",
+		2 * time.Second:         "2",
+	} {
+		s := &Service{Provider: &fakeProvider{}, EntityName: "fake", RetryAfter: retry}
+		w, _ := get(t, s, "/fake?name=a")
+		if got := w.Header().Get("Retry-After"); got != want {
+			t.Errorf("RetryAfter %v: expected Retry-After %s, got %s\n", retry, want, got)
+		}
+	}
+}
+func TestErrorFailed(t *testing.T) {
+	p := &fakeProvider{err: errors.New("upstream is down")}
+	s := new(Service)
+	if err := s.LoadProvider(p, "fake"); err == nil {
+		t.Fatal("Expected the load to fail")
+	}
+	w, e := get(t, s, "/fake?name=a")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(e.Message, "failed: upstream is down") {
+		t.Fatalf("Unexpected error %d %+v\n", w.Code, e)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Expected Retry-After 60, got %s\n", w.Header().Get("Retry-After"))
+	}
+}
+func TestErrorInternal(t *testing.T) {
+	p := &fakeProvider{searchErr: errors.New("something broke")}
+	s := new(Service)
+	s.LoadProvider(p, "fake")
+	w, e := get(t, s, "/fake?name=a")
+	if w.Code != http.StatusInternalServerError || e.Message != "something broke" {
+		t.Fatalf("Unexpected error %d %+v\n", w.Code, e)
+	}
+}
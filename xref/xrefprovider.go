@@ -0,0 +1,185 @@
+// Copyright 2014 Musicbeat.com. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package xref implements the methods of a stddata.Provider.
+It cross references the Federal Reserve E-Payments Routing
+Directory (package bank) with the Fedwire Funds Service
+participant directory (package fedwire), reporting how each
+routing number participates in ACH and Fedwire.
+*/
+package xref
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/musicbeat/stddata"
+	"github.com/musicbeat/stddata/bank"
+	"github.com/musicbeat/stddata/fedwire"
+	"github.com/musicbeat/stddata/index"
+)
+
+// XrefProvider implements the Provider interface.
+type XrefProvider struct {
+	// ACH and Fedwire are the directories that are joined. When
+	// either is nil, a provider with its default Source is used.
+	ACH     *bank.BankProvider
+	Fedwire *fedwire.FedwireProvider
+	// CacheDir is the CacheDir of the providers that Load creates.
+	CacheDir string
+	// status is the lifecycle of the data.
+	status stddata.Status
+	// loadMu allows one Load at a time.
+	loadMu sync.Mutex
+	// mu guards loaded, size and routingIndex, which Load replaces
+	// together.
+	mu           sync.RWMutex
+	loaded       bool
+	size         int
+	routingIndex *index.Index[Participation]
+}
+
+// Participation is the joined view of one routing number.
+type Participation struct {
+	Routing        string
+	ACHReceiver    bool
+	FedwireFunds   bool
+	BookEntry      bool
+	SettlementOnly bool
+	ACH            []bank.Bank           `json:",omitempty"`
+	Fedwire        []fedwire.Participant `json:",omitempty"`
+}
+
+// XrefResult is the interface{} that is returned from Search. Total is the
+// number of keys that matched, of which Participations holds the requested page.
+type XrefResult struct {
+	Total          int
+	Participations [][]Participation
+}
+
+// indexInfo describes the one index that Load builds.
+var indexInfo = []stddata.IndexInfo{
+	{Name: "number", Key: "Routing", Match: index.Prefix},
+}
+
+// Load loads both directories and joins them on routing number.
+func (p *XrefProvider) Load() (n int, err error) {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	p.status.Begin()
+	defer func() { p.status.Finish(err) }()
+
+	if p.ACH == nil {
+		p.ACH = &bank.BankProvider{CacheDir: p.CacheDir}
+	}
+	if p.Fedwire == nil {
+		p.Fedwire = &fedwire.FedwireProvider{CacheDir: p.CacheDir}
+	}
+	if _, err = p.ACH.Load(); err != nil {
+		return 0, err
+	}
+	if _, err = p.Fedwire.Load(); err != nil {
+		return 0, err
+	}
+	p.status.Derive(p.ACH.LoadInfo(), p.Fedwire.LoadInfo())
+	achDump, err := p.ACH.Search("number", "_dump", stddata.Page{})
+	if err != nil {
+		return 0, err
+	}
+	fedwireDump, err := p.Fedwire.Search("number", "_dump", stddata.Page{})
+	if err != nil {
+		return 0, err
+	}
+
+	joined := make(map[string]*Participation)
+	participation := func(routing string) *Participation {
+		x, found := joined[routing]
+		if !found {
+			x = &Participation{Routing: routing}
+			joined[routing] = x
+		}
+		return x
+	}
+	for _, banks := range achDump.(bank.BankResult).Banks {
+		for _, b := range banks {
+			x := participation(b.Routing)
+			x.ACH = append(x.ACH, b)
+			// a record type of 2 sends items to the new routing number
+			if b.RecordType() != bank.NewRouting {
+				x.ACHReceiver = true
+			}
+		}
+	}
+	for _, participants := range fedwireDump.(fedwire.FedwireResult).Participants {
+		for _, f := range participants {
+			x := participation(f.Routing)
+			x.Fedwire = append(x.Fedwire, f)
+			x.FedwireFunds = x.FedwireFunds || f.FundsTransferEligible()
+			x.BookEntry = x.BookEntry || f.BookEntryEligible()
+			x.SettlementOnly = x.SettlementOnly || f.SettlementOnly()
+		}
+	}
+
+	routingMap := make(map[string][]Participation)
+	for k, x := range joined {
+		routingMap[k] = []Participation{*x}
+	}
+	p.mu.Lock()
+	p.routingIndex = index.New(routingMap, index.Prefix)
+	p.size = len(routingMap)
+	p.loaded = true
+	p.mu.Unlock()
+	return len(routingMap), nil
+}
+
+// current returns the index of the most recent successful Load.
+func (p *XrefProvider) current() (loaded bool, routingIndex *index.Index[Participation]) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.loaded, p.routingIndex
+}
+
+// State implements the Provider interface.
+func (p *XrefProvider) State() (state stddata.State, err error) {
+	return p.status.State()
+}
+
+// LoadInfo implements the Provider interface. Its Source lists the
+// Sources of both directories.
+func (p *XrefProvider) LoadInfo() stddata.LoadInfo {
+	return p.status.Info()
+}
+
+// Indexes implements the Provider interface.
+func (p *XrefProvider) Indexes() []stddata.IndexInfo {
+	_, routingIndex := p.current()
+	indexes := map[string]*index.Index[Participation]{}
+	if routingIndex != nil {
+		indexes["number"] = routingIndex
+	}
+	return stddata.DescribeIndexes(indexInfo, indexes)
+}
+
+// Search returns a collection as an interface{} and error. The collection
+// contains an array of the results to the search. The only index is
+// "number": the routing numbers are searched using a regex-like 'query.*',
+// and the Participation of each matching routing number is returned in
+// the result. When the value of query is "_dump", every routing number
+// is returned.
+func (p *XrefProvider) Search(index string, query string, page stddata.Page) (result interface{}, err error) {
+	// make sure the data is loaded
+	loaded, routingIndex := p.current()
+	if loaded != true {
+		return nil, p.status.Unavailable("Cross reference")
+	}
+	if index != "number" {
+		// search cannot be performed
+		msg := "No index on " + index
+		return nil, &stddata.ServiceError{Msg: msg, Code: http.StatusBadRequest}
+	}
+	participations, total := routingIndex.SearchPage(query, page.Offset, page.Limit)
+	result = XrefResult{Total: total, Participations: participations}
+	return result, nil
+}
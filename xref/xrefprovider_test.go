@@ -0,0 +1,79 @@
+package xref
+
+import (
+	"testing"
+
+	. "github.com/musicbeat/stddata"
+	"github.com/musicbeat/stddata/bank"
+	"github.com/musicbeat/stddata/fedwire"
+)
+
+var p Provider
+
+// number of distinct routing numbers in the bank and fedwire fixtures
+var expected = 295
+
+func TestXrefProviderLoad(t *testing.T) {
+	p = &XrefProvider{
+		ACH:     &bank.BankProvider{Source: &FileSource{Path: "../bank/testdata/FedACHdir.txt"}},
+		Fedwire: &fedwire.FedwireProvider{Source: &FileSource{Path: "../fedwire/testdata/fpddir.txt"}},
+	}
+	n, err := p.Load()
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	if n != expected {
+		t.Fatalf("Expected to load %d, loaded %d\n", expected, n)
+	}
+}
+
+func TestXrefLoadInfo(t *testing.T) {
+	info := p.LoadInfo()
+	if info.Source != "../bank/testdata/FedACHdir.txt, ../fedwire/testdata/fpddir.txt" {
+		t.Fatalf("Unexpected source %s\n", info.Source)
+	}
+	if len(info.Checksum) != 64 {
+		t.Fatalf("Unexpected checksum %s\n", info.Checksum)
+	}
+}
+
+// search returns the Participation of one routing number.
+func search(t *testing.T, routing string) Participation {
+	res, err := p.Search("number", routing, Page{})
+	if err != nil {
+		t.Fatalf("Err %v\n", err)
+	}
+	participations := res.(XrefResult).Participations
+	if len(participations) != 1 {
+		t.Fatalf("Expected 1 number, got %d\n", len(participations))
+	}
+	return participations[0][0]
+}
+
+func TestBoth(t *testing.T) {
+	x := search(t, "011000015")
+	if !x.ACHReceiver || !x.FedwireFunds || !x.BookEntry || x.SettlementOnly {
+		t.Fatalf("Unexpected participation %+v\n", x)
+	}
+	if len(x.ACH) != 1 || len(x.Fedwire) != 1 {
+		t.Fatalf("Expected both records, got %+v\n", x)
+	}
+}
+func TestFedwireOnly(t *testing.T) {
+	x := search(t, "011600774")
+	if x.ACHReceiver || len(x.ACH) != 0 || len(x.Fedwire) != 1 {
+		t.Fatalf("Unexpected participation %+v\n", x)
+	}
+}
+func TestACHReplaced(t *testing.T) {
+	// record type 2: items go to the new routing number
+	x := search(t, "011001962")
+	if x.ACHReceiver || x.FedwireFunds || len(x.ACH) != 1 {
+		t.Fatalf("Unexpected participation %+v\n", x)
+	}
+}
+func TestUnknownIndex(t *testing.T) {
+	if _, err := p.Search("name", "A", Page{}); err == nil {
+		t.Fatal("Expected an error searching an unknown index")
+	}
+}